@@ -0,0 +1,101 @@
+// Coverage command
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/deze333/wiro"
+)
+
+// Prints coverage matrix of repository tree.
+func runCoverage(args []string) int {
+	flags := flag.NewFlagSet("coverage", flag.ExitOnError)
+	files := flags.String("files", defaultFiles, "comma separated resource file names or patterns")
+	asJSON := flags.Bool("json", false, "print report as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: wiro coverage [flags] <dir>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	if err := loadRepo(flags.Arg(0), *files); err != nil {
+		fmt.Fprintln(os.Stderr, "wiro:", err)
+		return 1
+	}
+
+	report, err := wiro.Coverage(repoId)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "wiro:", err)
+		return 1
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(report); err != nil {
+			fmt.Fprintln(os.Stderr, "wiro:", err)
+			return 1
+		}
+		return 0
+	}
+	printCoverage(os.Stdout, report)
+	return 0
+}
+
+// Prints report as table, one column per key,
+// resource row followed by its field rows.
+func printCoverage(out io.Writer, report *wiro.CoverageReport) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	header := []string{"RESOURCE", "FIELD"}
+	for _, k := range report.Keys {
+		header = append(header, k.DirName())
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+
+	for _, rc := range report.Resources {
+		row := []string{rc.Id, ""}
+		fields := []string{}
+		seen := map[string]bool{}
+		for _, vc := range rc.Variants {
+			row = append(row, vc.Status.String())
+			for _, fc := range vc.Fields {
+				if !seen[fc.Field] {
+					seen[fc.Field] = true
+					fields = append(fields, fc.Field)
+				}
+			}
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+
+		for _, field := range fields {
+			row = []string{"", field}
+			for _, vc := range rc.Variants {
+				row = append(row, fieldStatus(vc, field))
+			}
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+	}
+}
+
+// Returns field status within variant, '-' if variant has no such field.
+func fieldStatus(vc wiro.VariantCoverage, field string) string {
+	for _, fc := range vc.Fields {
+		if fc.Field == field {
+			return fc.Status.String()
+		}
+	}
+	return "-"
+}
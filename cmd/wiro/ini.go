@@ -0,0 +1,295 @@
+// Generic ini resource
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/deze333/wiro"
+)
+
+//------------------------------------------------------------
+// Ini document
+//------------------------------------------------------------
+
+// Parsed ini file. Sections named "map.<name> | <key>" are map
+// entries as read by skini, other sections are nested values.
+type iniDoc struct {
+	values   map[string]string
+	sections map[string]map[string]string
+	maps     map[string]map[string]map[string]string
+}
+
+func newIniDoc() *iniDoc {
+	return &iniDoc{
+		values:   map[string]string{},
+		sections: map[string]map[string]string{},
+		maps:     map[string]map[string]map[string]string{},
+	}
+}
+
+// Reads ini file into name/value pairs. Lines that are indented
+// or have no '=' continue previous value.
+func readIni(r io.Reader) (doc *iniDoc, err error) {
+	doc = newIniDoc()
+	values, last := doc.values, ""
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";"):
+			continue
+
+		case strings.HasPrefix(trimmed, "["):
+			if !strings.HasSuffix(trimmed, "]") {
+				return nil, fmt.Errorf("line %d: unterminated section %q", n, trimmed)
+			}
+			values, err = doc.section(strings.TrimSpace(trimmed[1 : len(trimmed)-1]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			last = ""
+
+		case line[0] == ' ' || line[0] == '\t' || !strings.Contains(line, "="):
+			if last == "" {
+				return nil, fmt.Errorf("line %d: value without name", n)
+			}
+			values[last] = strings.TrimPrefix(values[last]+"\n"+trimmed, "\n")
+
+		default:
+			i := strings.Index(line, "=")
+			last = strings.TrimSpace(strings.TrimSuffix(line[:i], "+"))
+			if last == "" {
+				return nil, fmt.Errorf("line %d: empty name", n)
+			}
+			values[last] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	return doc, scanner.Err()
+}
+
+// Returns values of section, creating it.
+func (doc *iniDoc) section(name string) (values map[string]string, err error) {
+	if !strings.HasPrefix(name, "map.") {
+		if doc.sections[name] == nil {
+			doc.sections[name] = map[string]string{}
+		}
+		return doc.sections[name], nil
+	}
+
+	i := strings.Index(name, "|")
+	if i < 0 {
+		return nil, fmt.Errorf("expected [map.<name> | <key>], got [%s]", name)
+	}
+	mapName := strings.TrimSpace(name[len("map."):i])
+	key := strings.TrimSpace(name[i+1:])
+	if doc.maps[mapName] == nil {
+		doc.maps[mapName] = map[string]map[string]string{}
+	}
+	if doc.maps[mapName][key] == nil {
+		doc.maps[mapName][key] = map[string]string{}
+	}
+	return doc.maps[mapName][key], nil
+}
+
+// Adds names of other document.
+func (doc *iniDoc) merge(other *iniDoc) {
+	for name := range other.values {
+		doc.values[name] = ""
+	}
+	for name, values := range other.sections {
+		if doc.sections[name] == nil {
+			doc.sections[name] = map[string]string{}
+		}
+		for k := range values {
+			doc.sections[name][k] = ""
+		}
+	}
+	for name := range other.maps {
+		doc.maps[name] = nil
+	}
+}
+
+//------------------------------------------------------------
+// Ini struct type
+//------------------------------------------------------------
+
+// Struct type shared by all variants of ini file so that variants
+// overlay field by field, as application structs read by skini do.
+// Top level values are string fields, sections are nested structs,
+// map sections are map fields. Field names are capitalized
+// (ie, map.friends is Friends), JSON names are original.
+type iniType struct {
+	typ      reflect.Type
+	values   map[string]int
+	sections map[string]iniSection
+	maps     map[string]int
+}
+
+type iniSection struct {
+	index  int
+	values map[string]int
+}
+
+var mapType = reflect.TypeOf(map[string]map[string]string{})
+
+// Builds struct type from names of all document variants.
+func newIniType(union *iniDoc) *iniType {
+	t := &iniType{values: map[string]int{}, sections: map[string]iniSection{}, maps: map[string]int{}}
+	fields := []reflect.StructField{}
+	used := map[string]bool{}
+
+	for _, name := range sortedKeys(union.values) {
+		t.values[name] = len(fields)
+		fields = append(fields, iniField(name, reflect.TypeOf(""), used))
+	}
+	for _, name := range sortedKeys(union.sections) {
+		sec := iniSection{index: len(fields), values: map[string]int{}}
+		secFields := []reflect.StructField{}
+		secUsed := map[string]bool{}
+		for _, k := range sortedKeys(union.sections[name]) {
+			sec.values[k] = len(secFields)
+			secFields = append(secFields, iniField(k, reflect.TypeOf(""), secUsed))
+		}
+		t.sections[name] = sec
+		fields = append(fields, iniField(name, reflect.StructOf(secFields), used))
+	}
+	for _, name := range sortedKeys(union.maps) {
+		t.maps[name] = len(fields)
+		fields = append(fields, iniField(name, mapType, used))
+	}
+
+	t.typ = reflect.StructOf(fields)
+	return t
+}
+
+// Returns pointer to struct holding document values.
+func (t *iniType) fill(doc *iniDoc) (data interface{}, err error) {
+	v := reflect.New(t.typ)
+	s := v.Elem()
+	for name, val := range doc.values {
+		i, ok := t.values[name]
+		if !ok {
+			return nil, fmt.Errorf("%s changed while loading", name)
+		}
+		s.Field(i).SetString(val)
+	}
+	for name, values := range doc.sections {
+		sec, ok := t.sections[name]
+		if !ok {
+			return nil, fmt.Errorf("[%s] changed while loading", name)
+		}
+		for k, val := range values {
+			i, ok := sec.values[k]
+			if !ok {
+				return nil, fmt.Errorf("[%s] %s changed while loading", name, k)
+			}
+			s.Field(sec.index).Field(i).SetString(val)
+		}
+	}
+	for name, m := range doc.maps {
+		i, ok := t.maps[name]
+		if !ok {
+			return nil, fmt.Errorf("[map.%s] changed while loading", name)
+		}
+		s.Field(i).Set(reflect.ValueOf(m))
+	}
+	return v.Interface(), nil
+}
+
+// Returns struct field for ini name, Go name is unique within struct.
+func iniField(name string, typ reflect.Type, used map[string]bool) reflect.StructField {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	goName := b.String()
+	if goName == "" || !unicode.IsLetter([]rune(goName)[0]) || !unicode.IsUpper([]rune(goName)[0]) {
+		goName = "F" + goName
+	}
+	for n, base := 2, goName; used[goName]; n++ {
+		goName = fmt.Sprintf("%s%d", base, n)
+	}
+	used[goName] = true
+
+	return reflect.StructField{
+		Name: goName,
+		Type: typ,
+		Tag:  reflect.StructTag(fmt.Sprintf("json:%q", name)),
+	}
+}
+
+func sortedKeys[V any](m map[string]V) (keys []string) {
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return
+}
+
+//------------------------------------------------------------
+// Ini resource
+//------------------------------------------------------------
+
+// Ini text resource, Data points to struct of ini file type.
+type IniText struct {
+	wiro.Key
+	Data interface{}
+}
+
+func (t *IniText) Get() interface{} {
+	return t.Data
+}
+
+// Returns parser of ini file name. Struct type is built from
+// all variants of file found in root subdirectories of fsys,
+// variants that fail to read are reported when parsed.
+func newIniParser(fsys fs.FS, name string) wiro.ReaderParser {
+	union := newIniDoc()
+	des, _ := fs.ReadDir(fsys, ".")
+	for _, de := range des {
+		if !de.IsDir() {
+			continue
+		}
+		f, err := fsys.Open(path.Join(de.Name(), name))
+		if err != nil {
+			continue
+		}
+		if doc, err := readIni(f); err == nil {
+			union.merge(doc)
+		}
+		f.Close()
+	}
+	t := newIniType(union)
+
+	return func(key wiro.Key, r io.Reader) (interface{}, error) {
+		doc, err := readIni(r)
+		if err != nil {
+			return nil, err
+		}
+		data, err := t.fill(doc)
+		if err != nil {
+			return nil, err
+		}
+		return &IniText{Key: key, Data: data}, nil
+	}
+}
@@ -0,0 +1,48 @@
+// Lint command
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/deze333/wiro"
+)
+
+// Checks repository tree, exits with 1 if problems are found.
+func runLint(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	files := flags.String("files", defaultFiles, "comma separated resource file names or patterns, other files are reported")
+	aliases := flags.String("aliases", wiro.DefaultAliasFile, "alias table file in repository root")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: wiro lint [flags] <dir>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	dir := flags.Arg(0)
+
+	parsers, err := repoParsers(dir, *files)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "wiro:", err)
+		return 1
+	}
+
+	problems, err := wiro.LintFS(os.DirFS(dir), parsers, *aliases)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "wiro:", err)
+		return 1
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "wiro: %d problem(s) found\n", len(problems))
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,68 @@
+// Command wiro inspects resource repository trees offline.
+//
+// Usage:
+//
+//	wiro <command> [flags] <args>
+//
+// Commands:
+//
+//	coverage   report present, inherited and missing content per key
+//	lint       check key directory names, unclaimed files and parse errors
+//	resolve    show fallback chain and overlaid object of resource
+//
+// Repository is loaded with generic parsers: .ini files are read as
+// name = value pairs, other files as raw text. Resource files are claimed
+// by -files, comma separated names or patterns matched against file name
+// or path inside key directory, default "*.ini,*.html". Lint reports
+// files that are not claimed.
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Command runs with its arguments and returns exit code.
+type command struct {
+	run   func(args []string) int
+	usage string
+}
+
+var commands = map[string]command{
+	"coverage": {runCoverage, "report present, inherited and missing content per key"},
+	"lint":     {runLint, "check key directory names, unclaimed files and parse errors"},
+	"resolve":  {runResolve, "show fallback chain and overlaid object of resource"},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := os.Args[1]
+	cmd, ok := commands[name]
+	if !ok {
+		if name != "help" && name != "-h" && name != "--help" {
+			fmt.Fprintf(os.Stderr, "wiro: unknown command %q\n", name)
+		}
+		usage()
+		os.Exit(2)
+	}
+	os.Exit(cmd.run(os.Args[2:]))
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: wiro <command> [flags] <args>")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun 'wiro <command> -h' for command flags.")
+}
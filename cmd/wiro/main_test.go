@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/deze333/wiro"
+)
+
+func TestCoverageSample(t *testing.T) {
+	if err := loadRepo("../../sample/txt", "*.ini"); err != nil {
+		t.Fatalf("Error loading sample: %s", err)
+	}
+	defer wiro.Close(repoId)
+
+	report, err := wiro.Coverage(repoId)
+	if err != nil {
+		t.Fatalf("Error reporting coverage: %s", err)
+	}
+
+	// Sections of default are inherited by all variants
+	var info wiro.ResourceCoverage
+	for _, rc := range report.Resources {
+		if rc.Id == "info.ini" {
+			info = rc
+		}
+	}
+	if len(info.Variants) != 5 {
+		t.Fatalf("Expected 5 variants of info.ini, got %v", info.Variants)
+	}
+	for _, vc := range info.Variants {
+		if vc.Key.DirName() == "_ _ _" {
+			continue
+		}
+		for _, fc := range vc.Fields {
+			want := wiro.Present
+			if strings.HasPrefix(fc.Field, "Friends.") || strings.HasPrefix(fc.Field, "Articles.") {
+				want = wiro.Inherited
+			}
+			if fc.Status != want {
+				t.Errorf("%s %s: expected %s, got %s", vc.Key.DirName(), fc.Field, want, fc.Status)
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	printCoverage(&out, report)
+	if !strings.Contains(out.String(), "Friends.alex.age") {
+		t.Errorf("Expected field rows, got:\n%s", out.String())
+	}
+}
+
+func TestLintSample(t *testing.T) {
+	parsers, err := repoParsers("../../sample/txt", "")
+	if err != nil {
+		t.Fatalf("Error discovering files: %s", err)
+	}
+	problems, err := wiro.LintFS(os.DirFS("../../sample/txt"), parsers, "")
+	if err != nil {
+		t.Fatalf("Error linting sample: %s", err)
+	}
+	if len(problems) != 1 || problems[0].Path != "_ _ _/home/Untitled Document" {
+		t.Errorf("Expected unclaimed file reported, got %v", problems)
+	}
+}
+
+func TestResolveSample(t *testing.T) {
+	if err := loadRepo("../../sample/txt", "info.ini"); err != nil {
+		t.Fatalf("Error loading sample: %s", err)
+	}
+	defer wiro.Close(repoId)
+
+	ex, err := wiro.Explain(repoId, "info.ini", "com.au")
+	if err != nil {
+		t.Fatalf("Error explaining: %s", err)
+	}
+	var out bytes.Buffer
+	if err = printResolve(&out, ex); err != nil {
+		t.Fatalf("Error printing: %s", err)
+	}
+
+	// Object is overlaid as served by Get
+	for _, want := range []string{`"name": "COM.AU name"`, `"friends": {`, `"alex": {`, `"articles": {`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %s in output:\n%s", want, out.String())
+		}
+	}
+}
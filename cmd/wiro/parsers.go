@@ -0,0 +1,167 @@
+// Generic resources and parsers for offline inspection
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/deze333/wiro"
+)
+
+//------------------------------------------------------------
+// Resources
+//------------------------------------------------------------
+
+// Raw text resource for any other file.
+type RawText struct {
+	wiro.Key
+	Text string
+}
+
+func (t *RawText) Get() interface{} {
+	return t
+}
+
+//------------------------------------------------------------
+// Repository loading
+//------------------------------------------------------------
+
+// Id of repository loaded by commands
+const repoId = "wiro"
+
+// Resource files claimed by default
+const defaultFiles = "*.ini,*.html"
+
+// Loads repository without watching. files is comma separated list
+// of resource file names or patterns, see repoParsers.
+func loadRepo(dir, files string) (err error) {
+	var parsers *wiro.ReaderParserLib
+	if parsers, err = repoParsers(dir, files); err != nil {
+		return
+	}
+	return wiro.CreateReaders(repoId, dir, parsers, wiro.Options{NoWatch: true})
+}
+
+// Returns parsers for files claimed by comma separated names
+// or patterns (path.Match syntax), matched against file name or
+// its path inside key directory. Empty files means defaultFiles.
+func repoParsers(dir, files string) (parsers *wiro.ReaderParserLib, err error) {
+	if files == "" {
+		files = defaultFiles
+	}
+	patterns := []string{}
+	for _, p := range strings.Split(files, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			if _, err = path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("bad file pattern %q: %w", p, err)
+			}
+			patterns = append(patterns, p)
+		}
+	}
+
+	fsys := os.DirFS(dir)
+	var names []string
+	if names, err = discoverFiles(fsys, patterns); err != nil {
+		return
+	}
+	return newParsers(fsys, names), nil
+}
+
+// Returns parser library for file names in fsys,
+// parser is chosen by extension.
+func newParsers(fsys fs.FS, names []string) *wiro.ReaderParserLib {
+	parsers := wiro.ReaderParserLib{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if path.Ext(name) == ".ini" {
+			parsers[name] = newIniParser(fsys, name)
+		} else {
+			parsers[name] = parseRaw
+		}
+	}
+	return &parsers
+}
+
+// Returns files found in root subdirectories that match any
+// of patterns, relative to subdirectory. Hidden files and
+// directories are skipped.
+func discoverFiles(fsys fs.FS, patterns []string) (names []string, err error) {
+	var des []fs.DirEntry
+	des, err = fs.ReadDir(fsys, ".")
+	if err != nil {
+		return
+	}
+
+	found := map[string]bool{}
+	for _, de := range des {
+		if !de.IsDir() {
+			continue
+		}
+		root := de.Name()
+		err = fs.WalkDir(fsys, root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if p != root && strings.HasPrefix(d.Name(), ".") {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			name := strings.TrimPrefix(p, root+"/")
+			if d.Type().IsRegular() && claimed(name, patterns) {
+				found[name] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// Checks if file matches any pattern by name or path.
+func claimed(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, path.Base(name)); ok {
+			return true
+		}
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+//------------------------------------------------------------
+// Parsers
+//------------------------------------------------------------
+
+// Reads file as raw text, which must be valid UTF-8.
+func parseRaw(key wiro.Key, r io.Reader) (rsrc interface{}, err error) {
+	var bytes []byte
+	bytes, err = io.ReadAll(r)
+	if err != nil {
+		return
+	}
+	if !utf8.Valid(bytes) {
+		return nil, fmt.Errorf("not valid UTF-8 text")
+	}
+	return &RawText{Key: key, Text: string(bytes)}, nil
+}
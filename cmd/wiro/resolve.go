@@ -0,0 +1,99 @@
+// Resolve command
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/deze333/wiro"
+)
+
+// Resolves resource for key and prints fallback chain and overlaid object.
+func runResolve(args []string) int {
+	flags := flag.NewFlagSet("resolve", flag.ExitOnError)
+	dir := flags.String("dir", "", "repository directory")
+	files := flags.String("files", "", "comma separated resource file names or patterns, default resolved resource only")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: wiro resolve -dir <dir> [flags] <resource> [domain [language [version]]]")
+		fmt.Fprintln(os.Stderr, "Use '_' for default domain, language or version.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if *dir == "" || flags.NArg() < 1 || flags.NArg() > 4 {
+		flags.Usage()
+		return 2
+	}
+
+	id := flags.Arg(0)
+	dlv := []string{}
+	for _, arg := range flags.Args()[1:] {
+		if arg == "_" {
+			arg = ""
+		}
+		dlv = append(dlv, arg)
+	}
+
+	if *files == "" {
+		*files = id
+	}
+	if err := loadRepo(*dir, *files); err != nil {
+		fmt.Fprintln(os.Stderr, "wiro:", err)
+		return 1
+	}
+
+	ex, err := wiro.Explain(repoId, id, dlv...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "wiro:", err)
+		return 1
+	}
+
+	if err = printResolve(os.Stdout, ex); err != nil {
+		fmt.Fprintln(os.Stderr, "wiro:", err)
+		return 1
+	}
+	return 0
+}
+
+// Prints chosen variant, fallback chain, inherited fields
+// and overlaid object as JSON.
+func printResolve(out io.Writer, ex *wiro.Explanation) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "resource\t%s\n", ex.Id)
+	fmt.Fprintf(w, "request\t%s\n", ex.Key.DirName())
+	if ex.Winner != nil {
+		fmt.Fprintf(w, "variant\t%s\t%s\n", ex.Dir, ex.File)
+	} else {
+		fmt.Fprintf(w, "variant\tnone\n")
+	}
+
+	fmt.Fprintln(w, "\nchain:")
+	for _, st := range ex.Steps {
+		found := "-"
+		if st.Found {
+			found = "found"
+		}
+		fmt.Fprintf(w, "  %d %s\t%s\t%s\n", st.Step, st.Rule, st.Key.DirName(), found)
+	}
+
+	if len(ex.Inherited) > 0 {
+		fmt.Fprintln(w, "\ninherited:")
+		for _, in := range ex.Inherited {
+			fmt.Fprintf(w, "  %s\tfrom %s\n", in.Field, in.From.DirName())
+		}
+	}
+	w.Flush()
+
+	if ex.Resource == nil {
+		return fmt.Errorf("%s not resolved", ex.Id)
+	}
+
+	fmt.Fprintln(out, "\nobject:")
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode((*ex.Resource).Get())
+}
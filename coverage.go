@@ -0,0 +1,206 @@
+// Translation and variant coverage
+package wiro
+
+import (
+    "fmt"
+    "reflect"
+    "sort"
+    "strings"
+)
+
+//------------------------------------------------------------
+// Coverage report
+//------------------------------------------------------------
+
+// Content status of resource variant or field.
+type CoverageStatus int
+
+const (
+    // Variant has its own file, field has its own value
+    Present CoverageStatus = iota
+    // Variant falls back to other key, field is inherited from default
+    Inherited
+    // Variant doesn't resolve, field is empty
+    Missing
+)
+
+// Coverage of repository resources across all keys present
+// in repository. Keys are sorted with default key first.
+type CoverageReport struct {
+    RepoId    string
+    Keys      []Key
+    Resources []ResourceCoverage
+}
+
+// Coverage of single resource, one variant per report key.
+type ResourceCoverage struct {
+    Id       string
+    Variants []VariantCoverage
+}
+
+// Coverage of resource variant. From is key of variant that
+// is served for requested Key. Fields are listed for all variants,
+// fields of inherited variants share variant status.
+type VariantCoverage struct {
+    Key    Key
+    Status CoverageStatus
+    From   Key
+    Fields []FieldCoverage
+}
+
+// Coverage of single field, nested fields and map entries
+// are dot separated (ie, Extra.Info, Friends.alex.age).
+type FieldCoverage struct {
+    Field  string
+    Status CoverageStatus
+}
+
+//------------------------------------------------------------
+// Exported functions
+//------------------------------------------------------------
+
+// Reports which resources and fields are present, inherited
+// or missing for every key of repository active snapshot.
+func Coverage(repoId string) (report *CoverageReport, err error) {
+    repo, ok := getRepo(repoId)
+    if !ok {
+        return nil, fmt.Errorf("Repository not found: %s", repoId)
+    }
+    return repo.Snapshot().coverage(repoId), nil
+}
+
+func (s CoverageStatus) String() string {
+    switch s {
+    case Present:
+        return "present"
+    case Inherited:
+        return "inherited"
+    case Missing:
+        return "missing"
+    }
+    return fmt.Sprintf("CoverageStatus(%d)", int(s))
+}
+
+func (s CoverageStatus) MarshalText() ([]byte, error) {
+    return []byte(s.String()), nil
+}
+
+//------------------------------------------------------------
+// Snapshot methods
+//------------------------------------------------------------
+
+func (s *Snapshot) coverage(repoId string) (report *CoverageReport) {
+    report = &CoverageReport{RepoId: repoId}
+    if s == nil {
+        return
+    }
+
+    // Collect distinct keys and resource ids
+    keys := map[variant]bool{}
+    ids := []string{}
+    for id, rsrcs := range s.Resources {
+        ids = append(ids, id)
+        for _, rsrc := range rsrcs {
+            keys[variantOf((*rsrc).GetKey())] = true
+        }
+    }
+    sort.Strings(ids)
+    for v := range keys {
+        report.Keys = append(report.Keys, Key{Domain: v.domain, Language: v.language, Version: v.version})
+    }
+    sort.Slice(report.Keys, func(i, j int) bool {
+        a, b := report.Keys[i], report.Keys[j]
+        if a.Domain != b.Domain {
+            return a.Domain < b.Domain
+        }
+        if a.Language != b.Language {
+            return a.Language < b.Language
+        }
+        return a.Version < b.Version
+    })
+
+    for _, id := range ids {
+        rc := ResourceCoverage{Id: id}
+        def := s.get(id, "", "", "")
+        for _, k := range report.Keys {
+            rc.Variants = append(rc.Variants, s.variantCoverage(id, k, def))
+        }
+        report.Resources = append(report.Resources, rc)
+    }
+    return
+}
+
+// Returns coverage of resource variant with given key.
+func (s *Snapshot) variantCoverage(id string, k Key, def *Resource) (vc VariantCoverage) {
+    vc = VariantCoverage{Key: k, Status: Missing}
+    rsrc := s.get(id, k.Domain, k.Language, k.Version)
+    if rsrc != nil {
+        from := (*rsrc).GetKey()
+        vc.From = Key{Domain: from.Domain, Language: from.Language, Version: from.Version}
+        vc.Status = Present
+        if variantOf(from) != variantOf(&k) {
+            vc.Status = Inherited
+        }
+    }
+
+    // Fields are those of served variant and default
+    var fields, defFields map[string]reflect.Value
+    if rsrc != nil {
+        fields = fieldValues((*rsrc).Get())
+    }
+    if def != nil {
+        defFields = fieldValues((*def).Get())
+    }
+    names := []string{}
+    for name := range fields {
+        names = append(names, name)
+    }
+    for name := range defFields {
+        if _, ok := fields[name]; !ok {
+            names = append(names, name)
+        }
+    }
+    sort.Strings(names)
+    names = leafNames(names)
+
+    inherited := s.inherited[rsrc]
+    for _, name := range names {
+        fc := FieldCoverage{Field: name, Status: vc.Status}
+        if vc.Status == Present {
+            val, ok := fields[name]
+            switch {
+            case isInheritedField(name, inherited):
+                fc.Status = Inherited
+            case !ok || isEmptyValue(val):
+                fc.Status = Missing
+            }
+        }
+        vc.Fields = append(vc.Fields, fc)
+    }
+    return
+}
+
+// Drops names that are parents of other names,
+// ie, empty map of variant when default has map entries.
+func leafNames(names []string) (leaves []string) {
+NameLoop:
+    for _, name := range names {
+        for _, other := range names {
+            if strings.HasPrefix(other, name + ".") {
+                continue NameLoop
+            }
+        }
+        leaves = append(leaves, name)
+    }
+    return
+}
+
+// Checks if field or any of its parents is inherited.
+func isInheritedField(name string, inherited []string) bool {
+    for _, in := range inherited {
+        if name == in || strings.HasPrefix(name, in + ".") {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,164 @@
+// Debug HTTP handler
+package wiro
+
+import (
+    "encoding/json"
+    "net/http"
+    "sort"
+    "time"
+)
+
+//------------------------------------------------------------
+// Debug views
+//------------------------------------------------------------
+
+// Repository state as served by debug handler
+type debugRepo struct {
+    Id         string
+    Dir        string
+    Zip        string `json:",omitempty"`
+    WatchIds   []int
+    Generation int
+    Loaded     time.Time
+    LastReload *debugReload `json:",omitempty"`
+    Resources  map[string][]debugKey `json:",omitempty"`
+}
+
+// Reload event with errors as text
+type debugReload struct {
+    Time       time.Time
+    Duration   string
+    Generation int
+    Rollback   bool
+    Rejected   bool
+    Paths      []string `json:",omitempty"`
+    Errors     []string `json:",omitempty"`
+}
+
+// Resource variant
+type debugKey struct {
+    Key     string
+    File    string
+    ModTime time.Time
+}
+
+// Resolved resource with its resolution
+type debugResolve struct {
+    Explanation *Explanation
+    Object      interface{}
+}
+
+//------------------------------------------------------------
+// Handler
+//------------------------------------------------------------
+
+// Returns handler serving library state as JSON, to be mounted
+// on debug server like expvar and pprof handlers:
+//
+//    http.Handle("/debug/wiro", wiro.Handler())
+//
+// Without parameters lists all repositories. Query parameters:
+//    repo      lists single repository with its resources
+//    id        with repo, renders resolved resource and its resolution
+//    d, l, v   domain, language and version to resolve, default if omitted
+func Handler() http.Handler {
+    return http.HandlerFunc(serveDebug)
+}
+
+func serveDebug(w http.ResponseWriter, req *http.Request) {
+    q := req.URL.Query()
+    repoId := q.Get("repo")
+
+    var view interface{}
+    switch {
+    case repoId == "":
+        _libraryMu.RLock()
+        repos := make([]*Repo, 0, len(_library))
+        for _, repo := range _library {
+            repos = append(repos, repo)
+        }
+        _libraryMu.RUnlock()
+
+        sort.Slice(repos, func(i, j int) bool {
+            return repos[i].Id < repos[j].Id
+        })
+        list := []debugRepo{}
+        for _, repo := range repos {
+            list = append(list, repo.debugView(false))
+        }
+        view = list
+
+    default:
+        repo, ok := getRepo(repoId)
+        if !ok {
+            http.Error(w, "Repository not found: " + repoId, http.StatusNotFound)
+            return
+        }
+        if id := q.Get("id"); id != "" {
+            ex := repo.Snapshot().Explain(id, q.Get("d"), q.Get("l"), q.Get("v"))
+            ex.RepoId = repoId
+            res := debugResolve{Explanation: ex}
+            if ex.Resource != nil {
+                res.Object = (*ex.Resource).Get()
+            }
+            view = res
+        } else {
+            view = repo.debugView(true)
+        }
+    }
+
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    enc := json.NewEncoder(w)
+    enc.SetIndent("", "  ")
+    if err := enc.Encode(view); err != nil {
+        logger().Warn("Error encoding debug view", "err", err)
+    }
+}
+
+// Returns repository state, with resource variants if requested.
+func (repo *Repo) debugView(resources bool) (view debugRepo) {
+    view = debugRepo{Id: repo.Id, Dir: repo.Dir, Zip: repo.Zip}
+
+    // Never waits for reload in progress
+    repo.mu.Lock()
+    view.WatchIds = append([]int{}, repo.WatchIds...)
+    ev := repo.lastEvent
+    repo.mu.Unlock()
+    if ev != nil {
+        view.LastReload = &debugReload{
+            Time: ev.Time,
+            Duration: ev.Duration.String(),
+            Generation: ev.Generation,
+            Rollback: ev.Rollback,
+            Rejected: ev.Rejected,
+            Paths: ev.Paths,
+        }
+        for _, err := range ev.Errors {
+            view.LastReload.Errors = append(view.LastReload.Errors, err.Error())
+        }
+    }
+
+    snap := repo.Snapshot()
+    if snap == nil {
+        return
+    }
+    view.Generation = snap.Generation
+    view.Loaded = snap.Time
+    if !resources {
+        return
+    }
+
+    view.Resources = map[string][]debugKey{}
+    for id, rsrcs := range snap.Resources {
+        keys := []debugKey{}
+        for _, rsrc := range rsrcs {
+            k := (*rsrc).GetKey()
+            keys = append(keys, debugKey{Key: k.DirName(), File: k.fileName(), ModTime: k.ModTime})
+        }
+        sort.Slice(keys, func(i, j int) bool {
+            return keys[i].Key < keys[j].Key
+        })
+        view.Resources[id] = keys
+    }
+    return
+}
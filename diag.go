@@ -1,34 +1,74 @@
 // Diagnostics inteface
 package wiro
 
-import(
-    _D "github.com/deze333/diag"
+import (
+    "context"
+    "log/slog"
+    "sync/atomic"
 )
 
 // Package name for diagnostics messages
 const _me = "wiro"
 
+// Package logger, nil means slog.Default()
+var _logger atomic.Pointer[slog.Logger]
+
+// Sets package logger used by repositories without own logger
+// and for messages not related to single repository.
+// Nil means slog.Default().
+func SetLogger(l *slog.Logger) {
+    _logger.Store(l)
+}
+
+// Returns package logger.
+func logger() *slog.Logger {
+    if l := _logger.Load(); l != nil {
+        return l
+    }
+    return slog.Default()
+}
+
+// Returns repository logger with repository id attribute.
+func (r *Repo) log() *slog.Logger {
+    l := r.opts.Logger
+    if l == nil {
+        l = logger()
+    }
+    return l.With("repo", r.Id)
+}
+
+//------------------------------------------------------------
+// Legacy diagnostics, logged via package logger.
+// v is list of alternating attribute keys and values.
+//------------------------------------------------------------
 
 func DEBUG(location, title string, v ...interface{}) {
-    _D.DEBUG("[" + _me +" : " + location +"]", title, v...)
+    logAt(slog.LevelDebug, location, title, v...)
 }
 
 func NOTE(msg string, v ...interface{}) {
-    _D.NOTE(msg, v...)
+    logAt(slog.LevelInfo, "", msg, v...)
 }
 
 func NOTE2(msg string, v ...interface{}) {
-    _D.NOTE2(msg, v...)
+    logAt(slog.LevelInfo, "", msg, v...)
 }
 
 func WARNING(location, title string, v ...interface{}) {
-    _D.WARNING("[" + _me +" : " + location +"]", title, v...)
+    logAt(slog.LevelWarn, location, title, v...)
 }
 
 func ERROR(location, title string, v ...interface{}) {
-    _D.ERROR("[" + _me +" : " + location +"]", title, v...)
+    logAt(slog.LevelError, location, title, v...)
 }
 
 func SOS(location, title string, v ...interface{}) {
-    _D.SOS("[" + _me +" : " + location +"]", title, v...)
+    logAt(slog.LevelError, location, title, v...)
+}
+
+func logAt(level slog.Level, location, msg string, v ...interface{}) {
+    if location != "" {
+        v = append([]interface{}{"at", _me + ":" + location}, v...)
+    }
+    logger().Log(context.Background(), level, msg, v...)
 }
@@ -0,0 +1,100 @@
+// Resource resolution explained
+package wiro
+
+import (
+    "fmt"
+)
+
+//------------------------------------------------------------
+// Explanation
+//------------------------------------------------------------
+
+// Explains how resource was resolved for requested key:
+// fallback steps tried, keys resource exists in, winning variant
+// and fields inherited through overlay.
+// Resource is nil if no step matched. File is full path of winning
+// file, or its path inside file system or zip bundle.
+type Explanation struct {
+    RepoId     string
+    Id         string
+    Key        Key
+    Generation int
+    Steps      []ExplainStep
+    Candidates []Key
+    Resource   *Resource `json:"-"`
+    Winner     *Key
+    Dir        string
+    File       string
+    Inherited  []InheritedField
+}
+
+// Single fallback step, Key is candidate key tried.
+type ExplainStep struct {
+    Step  int
+    Rule  string
+    Key   Key
+    Found bool
+}
+
+// Field of winning variant that was empty
+// and got its value from another variant.
+type InheritedField struct {
+    Field string
+    From  Key
+}
+
+//------------------------------------------------------------
+// Exported functions
+//------------------------------------------------------------
+
+// Explains resolution of resource in active snapshot of repository.
+// dlv is domain, language, version which can be omitted meaning default.
+func Explain(repoId, id string, dlv ...string) (ex *Explanation, err error) {
+    r, ok := getRepo(repoId)
+    if !ok {
+        return nil, fmt.Errorf("Repository not found: %s", repoId)
+    }
+    ex = r.Snapshot().Explain(id, dlv...)
+    ex.RepoId = repoId
+    return
+}
+
+// Explains resolution of resource in snapshot, as Get resolves it.
+func (s *Snapshot) Explain(id string, dlv ...string) (ex *Explanation) {
+    domain, language, version := s.normalize(splitDLV(dlv))
+    ex = &Explanation{
+        Id: id,
+        Key: Key{Id: id, Domain: domain, Language: language, Version: version},
+    }
+    if s == nil {
+        return
+    }
+    ex.Generation = s.Generation
+
+    for _, rsrc := range s.Resources[id] {
+        k := (*rsrc).GetKey()
+        ex.Candidates = append(ex.Candidates, Key{Domain: k.Domain, Language: k.Language, Version: k.Version})
+    }
+
+    ex.Resource, _ = s.resolve(id, domain, language, version, &ex.Steps)
+    if ex.Resource == nil {
+        return
+    }
+
+    winner := *(*ex.Resource).GetKey()
+    ex.Winner = &winner
+    ex.Dir = winner.DirName()
+    ex.File = winner.fileName()
+
+    // Overlay always inherits from default variant
+    if fields := s.inherited[ex.Resource]; len(fields) > 0 {
+        var from Key
+        if def := s.get(id, "", "", ""); def != nil {
+            from = *(*def).GetKey()
+        }
+        for _, field := range fields {
+            ex.Inherited = append(ex.Inherited, InheritedField{Field: field, From: from})
+        }
+    }
+    return
+}
@@ -0,0 +1,113 @@
+// Wiro metrics published via expvar
+
+package expvarmetrics
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/deze333/wiro"
+	"github.com/deze333/wiro/fwatch"
+)
+
+//------------------------------------------------------------
+// Exported functions
+//------------------------------------------------------------
+
+// Publishes metrics of all repositories as expvar map with given name
+// and makes them active via wiro.SetMetrics. Importing this package
+// registers expvar handler on http.DefaultServeMux.
+func Publish(name string) (wiro.Metrics, error) {
+	m, err := New(name)
+	if err != nil {
+		return nil, err
+	}
+	wiro.SetMetrics(m)
+	return m, nil
+}
+
+// Creates metrics published as expvar map with given name without
+// activating them. Name must not be already published.
+//
+//	"wiro": {
+//	    "watches": 12,
+//	    "repos": {
+//	        "text": {
+//	            "gets": 1200, "misses": 3,
+//	            "steps": {"exact": 900, "ignore language": 40, "default": 257, "miss": 3},
+//	            "reloads": 2, "rejected": 0, "reload_ms": 12.5, "last_reload_ms": 6.1,
+//	            "parse_errors": 1
+//	        }
+//	    }
+//	}
+func New(name string) (wiro.Metrics, error) {
+	_mu.Lock()
+	defer _mu.Unlock()
+	if expvar.Get(name) != nil {
+		return nil, fmt.Errorf("expvar %q already published", name)
+	}
+
+	m := &metrics{repos: new(expvar.Map).Init()}
+	root := expvar.NewMap(name)
+	root.Set("repos", m.repos)
+	root.Set("watches", expvar.Func(func() interface{} {
+		return fwatch.Count()
+	}))
+	return m, nil
+}
+
+//------------------------------------------------------------
+// Metrics
+//------------------------------------------------------------
+
+// Serializes publishing so name check and expvar.NewMap are atomic
+var _mu sync.Mutex
+
+type metrics struct {
+	repos *expvar.Map
+	mu    sync.Mutex
+}
+
+func (m *metrics) Lookup(repoId, id string, step int) {
+	repo := m.child(m.repos, repoId)
+	repo.Add("gets", 1)
+	if step == 0 {
+		repo.Add("misses", 1)
+	}
+	m.child(repo, "steps").Add(wiro.StepName(step), 1)
+}
+
+func (m *metrics) Reload(repoId string, d time.Duration, rejected bool) {
+	repo := m.child(m.repos, repoId)
+	repo.Add("reloads", 1)
+	if rejected {
+		repo.Add("rejected", 1)
+	}
+	ms := float64(d) / float64(time.Millisecond)
+	repo.AddFloat("reload_ms", ms)
+	last := new(expvar.Float)
+	last.Set(ms)
+	repo.Set("last_reload_ms", last)
+}
+
+func (m *metrics) ParseError(repoId, file string) {
+	m.child(m.repos, repoId).Add("parse_errors", 1)
+}
+
+// Returns nested map, creating it if needed.
+func (m *metrics) child(parent *expvar.Map, name string) *expvar.Map {
+	if v, ok := parent.Get(name).(*expvar.Map); ok {
+		return v
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if v, ok := parent.Get(name).(*expvar.Map); ok {
+		return v
+	}
+	v := new(expvar.Map).Init()
+	parent.Set(name, v)
+	return v
+}
@@ -0,0 +1,55 @@
+// Tester
+package expvarmetrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/deze333/wiro"
+)
+
+// Runs of TestPublish, expvar names can't be published twice
+var runs int
+
+func TestPublish(t *testing.T) {
+	runs++
+	name := fmt.Sprintf("wiro_test_%d", runs)
+	m, err := Publish(name)
+	if err != nil {
+		t.Fatalf("Error publishing metrics: %s", err)
+	}
+	defer wiro.SetMetrics(nil)
+
+	if _, err := Publish(name); err == nil {
+		t.Errorf("Expected error publishing same name twice")
+	}
+
+	m.Lookup("text", "info.txt", 1)
+	m.Lookup("text", "info.txt", 0)
+	m.Reload("text", 5*time.Millisecond, false)
+	m.ParseError("text", "bad.txt")
+
+	var got struct {
+		Watches int
+		Repos   map[string]struct {
+			Gets        int            `json:"gets"`
+			Misses      int            `json:"misses"`
+			Steps       map[string]int `json:"steps"`
+			Reloads     int            `json:"reloads"`
+			ParseErrors int            `json:"parse_errors"`
+		}
+	}
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &got); err != nil {
+		t.Fatalf("Error decoding expvar: %s", err)
+	}
+	text := got.Repos["text"]
+	if text.Gets != 2 || text.Misses != 1 || text.Steps["exact"] != 1 || text.Steps["miss"] != 1 {
+		t.Errorf("Unexpected lookup metrics: %+v", text)
+	}
+	if text.Reloads != 1 || text.ParseErrors != 1 {
+		t.Errorf("Unexpected reload metrics: %+v", text)
+	}
+}
@@ -3,7 +3,11 @@
 package fwatch
 
 import (
-	"fmt"
+	"log/slog"
+	"path"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -17,64 +21,242 @@ const (
 	// Time to elapse without changes take place
 	fileDamper     time.Duration = 3 * time.Second
 	callbackDamper time.Duration = 1 * time.Second
+	// Interval between polls of polled watches
+	pollInterval time.Duration = 2 * time.Second
 )
 
+//------------------------------------------------------------
+// Options
+//------------------------------------------------------------
+
+// Watch options. Zero values mean defaults.
+type Options struct {
+	// Time to elapse without changes in watched directory
+	// before watch is considered changed.
+	FileDamper time.Duration
+	// Time to elapse after last changed watch of a repository
+	// before repository callback is executed.
+	CallbackDamper time.Duration
+	// File name patterns (path.Match syntax) to watch.
+	// Nil means all files.
+	Include []string
+	// File name patterns (path.Match syntax) to ignore.
+	// Nil means DefaultExclude, empty slice means none.
+	Exclude []string
+	// Operations that trigger callback.
+	// Zero means DefaultOps.
+	Ops fsnotify.Op
+	// Use polling instead of fsnotify. Polling is also used
+	// automatically when fsnotify watch can't be added.
+	Poll bool
+	// Interval between polls.
+	PollInterval time.Duration
+	// Compare file content hashes in addition to size and
+	// modification time when polling.
+	PollHash bool
+	// Logger for watch messages.
+	// Nil means package logger, see SetLogger.
+	Logger *slog.Logger
+}
+
+// Editor temporary and OS metadata files
+var DefaultExclude = []string{
+	"*.swp",
+	"*.swx",
+	"*~",
+	".#*",
+	"#*#",
+	"4913",
+	".DS_Store",
+}
+
+// Content changing operations, Chmod is ignored
+const DefaultOps = fsnotify.Create | fsnotify.Write | fsnotify.Remove | fsnotify.Rename
+
+// Default watch options
+var DefaultOptions = Options{
+	FileDamper:     fileDamper,
+	CallbackDamper: callbackDamper,
+	Exclude:        DefaultExclude,
+	Ops:            DefaultOps,
+	PollInterval:   pollInterval,
+}
+
+// Returns options with zero values replaced by defaults.
+func (o Options) withDefaults() Options {
+	if o.FileDamper <= 0 {
+		o.FileDamper = DefaultOptions.FileDamper
+	}
+	if o.CallbackDamper <= 0 {
+		o.CallbackDamper = DefaultOptions.CallbackDamper
+	}
+	if o.Exclude == nil {
+		o.Exclude = DefaultOptions.Exclude
+	}
+	if o.Ops == 0 {
+		o.Ops = DefaultOptions.Ops
+	}
+	if o.PollInterval <= 0 {
+		o.PollInterval = DefaultOptions.PollInterval
+	}
+	return o
+}
+
+// Checks if event is relevant according to options.
+// Events on watched path itself are only filtered by operation.
+func (o *Options) accepts(ev fsnotify.Event, watched string) bool {
+	if ev.Op&o.Ops == 0 {
+		return false
+	}
+
+	name := filepath.Clean(ev.Name)
+	if name == watched {
+		return true
+	}
+
+	base := filepath.Base(name)
+	for _, p := range o.Exclude {
+		if ok, _ := path.Match(p, base); ok {
+			return false
+		}
+	}
+
+	if o.Include == nil {
+		return true
+	}
+	for _, p := range o.Include {
+		if ok, _ := path.Match(p, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
 //------------------------------------------------------------
 // Watch
 //------------------------------------------------------------
 
+// Callback receives repository ids given at watch creation.
+type Callback func(rid, rid2 string)
+
+// PathsCallback receives repository ids given at watch creation
+// and changed paths that triggered the callback.
+type PathsCallback func(rid, rid2 string, paths []string)
+
 // Watch definition
 type Watch struct {
 	id       string
 	id2      string
 	dir      string
-	watcher  *fsnotify.Watcher
-	callback func(string, string)
-	tdelta   time.Duration
-	timer    *time.Timer
+	callback PathsCallback
+	opts     Options
+	due      time.Time
+	paths    []string
+	poller   *poller
 }
 
-// Active watches map
-var _watches = map[int]*Watch{}
-
-// Next watch map id
-var _watchNextId = 0
-
 //------------------------------------------------------------
 // Callbacker
 //------------------------------------------------------------
 
-// Callback damper, prevents from multiple callbacks on same repository
+// Callback damper, prevents from multiple callbacks on same repository.
+// Callbacks of a repository never run concurrently: callbacks
+// that become due while previous ones still run are deferred.
 type Callbacker struct {
 	watches []*Watch
-	tdelta  time.Duration
-	timer   *time.Timer
+	paths   []string
+	due     time.Time
+	running bool
 }
 
-// Callbacker
-var _callbacker = Callbacker{tdelta: callbackDamper}
-
 //------------------------------------------------------------
-// Callbacker methods
+// Event loop
 //------------------------------------------------------------
 
-func (c *Callbacker) execute() {
-	executed := []string{}
-	for _, w := range c.watches {
-		isExecuted := false
-		for _, id := range executed {
-			if w.id == id {
-				isExecuted = true
-				break
-			}
-		}
-		if isExecuted {
-			continue
+// All watch state is owned by single event loop goroutine.
+// Exported functions talk to the loop via requests.
+// Loop runs only while there are watches.
+type request struct {
+	add   *Watch
+	close []int
+	reply chan reply
+}
+
+type reply struct {
+	id   int
+	err  error
+	idle bool
+}
+
+// Event loop state
+type loop struct {
+	watcher     *fsnotify.Watcher
+	watches     map[int]*Watch
+	paths       map[string][]int
+	notified    map[string]int
+	callbackers map[string]*Callbacker
+	timer       *time.Timer
+}
+
+var (
+	_requests = make(chan request)
+	_done     = make(chan string)
+	_polled   = make(chan fsnotify.Event, 64)
+	// Serializes requests and guards _running
+	_mu      sync.Mutex
+	_running bool
+	// Next watch id, kept across loop restarts
+	_nextId int
+	// Number of active watches, read without loop
+	_count atomic.Int64
+	// Package logger, nil means slog.Default()
+	_logger atomic.Pointer[slog.Logger]
+	// Shared watcher constructor, replaced in tests
+	newWatcher = fsnotify.NewWatcher
+)
+
+// Returns package logger.
+func logger() *slog.Logger {
+	if l := _logger.Load(); l != nil {
+		return l
+	}
+	return slog.Default()
+}
+
+// Returns watch logger.
+func (o Options) logger() *slog.Logger {
+	if o.Logger != nil {
+		return o.Logger
+	}
+	return logger()
+}
+
+// Sends request to event loop, starting the loop if needed.
+// Loop exits by itself once no watches are left.
+func send(r request) reply {
+	_mu.Lock()
+	defer _mu.Unlock()
+
+	if !_running {
+		_running = true
+		l := &loop{
+			watches:     map[int]*Watch{},
+			paths:       map[string][]int{},
+			notified:    map[string]int{},
+			callbackers: map[string]*Callbacker{},
+			timer:       time.NewTimer(time.Hour),
 		}
-		executed = append(executed, w.id)
-		w.callback(w.id, w.id2)
+		l.timer.Stop()
+		go l.run()
 	}
-	c.watches = []*Watch{}
+
+	r.reply = make(chan reply, 1)
+	_requests <- r
+	res := <-r.reply
+	if res.idle {
+		_running = false
+	}
+	return res
 }
 
 //------------------------------------------------------------
@@ -83,14 +265,50 @@ func (c *Callbacker) execute() {
 
 // Adds directory watcher.
 // rid is repository id that will be passed to callback.
-func WatchDir(dir string, rid, rid2 string, callback func(string, string)) (id int, err error) {
-	return addWatch(dir, rid, rid2, callback)
+// Optional opts override default damper timings and event filters.
+func WatchDir(dir string, rid, rid2 string, callback Callback, opts ...Options) (id int, err error) {
+	return addWatch(dir, rid, rid2, withoutPaths(callback), opts...)
+}
+
+// Adds directory watcher with callback receiving changed paths.
+func WatchDirPaths(dir string, rid, rid2 string, callback PathsCallback, opts ...Options) (id int, err error) {
+	return addWatch(dir, rid, rid2, callback, opts...)
 }
 
 // Adds specific file watcher.
 // rid is repository id that will be passed to callback.
-func WatchFile(dir string, rid, rid2 string, callback func(string, string)) (id int, err error) {
-	return addWatch(dir, rid, rid2, callback)
+// Optional opts override default damper timings and event filters.
+func WatchFile(dir string, rid, rid2 string, callback Callback, opts ...Options) (id int, err error) {
+	return addWatch(dir, rid, rid2, withoutPaths(callback), opts...)
+}
+
+// Adds specific file watcher with callback receiving changed paths.
+func WatchFilePaths(dir string, rid, rid2 string, callback PathsCallback, opts ...Options) (id int, err error) {
+	return addWatch(dir, rid, rid2, callback, opts...)
+}
+
+// Closes several existing watch.
+func CloseMany(ids []int) {
+	if len(ids) == 0 {
+		return
+	}
+	send(request{close: ids})
+}
+
+// Closes existing watch.
+func Close(id int) {
+	CloseMany([]int{id})
+}
+
+// Returns number of active watches.
+func Count() int {
+	return int(_count.Load())
+}
+
+// Sets package logger used for shared watcher messages
+// and watches without own logger. Nil means slog.Default().
+func SetLogger(l *slog.Logger) {
+	_logger.Store(l)
 }
 
 //------------------------------------------------------------
@@ -98,98 +316,336 @@ func WatchFile(dir string, rid, rid2 string, callback func(string, string)) (id
 //------------------------------------------------------------
 
 // Creates customizable watcher.
-func addWatch(dir string, rid, rid2 string, callback func(string, string)) (id int, err error) {
-	var watcher *fsnotify.Watcher
-	watcher, err = fsnotify.NewWatcher()
-	if err != nil {
-		return -1, err
+// All watches share single fsnotify watcher,
+// events are dispatched to watches by path.
+func addWatch(dir string, rid, rid2 string, callback PathsCallback, opts ...Options) (id int, err error) {
+	var o Options
+	if len(opts) > 0 {
+		o = opts[0]
 	}
 
-	w := &Watch{rid, rid2, dir, watcher, callback, fileDamper, nil}
+	w := &Watch{id: rid, id2: rid2, dir: filepath.Clean(dir), callback: callback, opts: o.withDefaults()}
+	res := send(request{add: w})
+	return res.id, res.err
+}
+
+// Adapts callback that doesn't need changed paths.
+func withoutPaths(callback Callback) PathsCallback {
+	return func(rid, rid2 string, paths []string) {
+		callback(rid, rid2)
+	}
+}
+
+// Event loop goroutine.
+func (l *loop) run() {
+	var events chan fsnotify.Event
+	var errors chan error
+	for {
+		if l.watcher != nil {
+			events, errors = l.watcher.Events, l.watcher.Errors
+		}
 
-	err = watcher.Add(dir)
+		select {
+		case r := <-_requests:
+			var res reply
+			if r.add != nil {
+				res.id, res.err = l.add(r.add)
+			} else {
+				for _, id := range r.close {
+					l.close(id)
+				}
+			}
+			// Sender marks loop as stopped when idle
+			res.idle = l.idle()
+			r.reply <- res
+			if res.idle {
+				l.shutdown()
+				return
+			}
 
-	if err != nil {
-		return -1, err
+		case ev, ok := <-events:
+			if !ok {
+				l.watcher = nil
+				events, errors = nil, nil
+				continue
+			}
+			//fmt.Println("[fwatch] change", ev)
+			l.dispatch(ev)
+
+		case ev := <-_polled:
+			l.dispatch(ev)
+
+		case err, ok := <-errors:
+			if !ok {
+				l.watcher = nil
+				events, errors = nil, nil
+				continue
+			}
+			logger().Error("Watcher error", "err", err)
+
+		case <-l.timer.C:
+			l.fire(time.Now())
+
+		case rid := <-_done:
+			if c, ok := l.callbackers[rid]; ok {
+				c.running = false
+				if len(c.watches) == 0 {
+					delete(l.callbackers, rid)
+				}
+			}
+			l.fire(time.Now())
+		}
+
+		l.resetTimer()
+
+		// Exit when idle, unless request is being sent,
+		// in which case its reply decides
+		if l.idle() && _mu.TryLock() {
+			_running = false
+			_mu.Unlock()
+			l.shutdown()
+			return
+		}
 	}
+}
 
-	go watch(w)
+// Checks if loop has no watches and no pending or running callbacks.
+func (l *loop) idle() bool {
+	return len(l.watches) == 0 && len(l.callbackers) == 0
+}
 
-	_watchNextId++
-	_watches[_watchNextId] = w
-	return _watchNextId, err
+// Releases shared watcher and timer.
+func (l *loop) shutdown() {
+	l.timer.Stop()
+	if l.watcher != nil {
+		l.watcher.Close()
+		l.watcher = nil
+	}
 }
 
-// Closes several existing watch.
-func CloseMany(ids []int) {
-	for _, id := range ids {
-		Close(id)
+// Registers watch, adding its path to shared watcher if needed.
+// Falls back to polling when fsnotify is not available for the path.
+func (l *loop) add(w *Watch) (id int, err error) {
+	if !w.opts.Poll {
+		err = l.notify(w.dir)
+		if err != nil {
+			w.opts.logger().Warn("Falling back to polling", "dir", w.dir, "err", err)
+		}
+	}
+
+	if w.opts.Poll || err != nil {
+		w.poller, err = startPoller(w.dir, w.opts)
+		if err != nil {
+			return -1, err
+		}
 	}
+
+	_nextId++
+	_count.Add(1)
+	l.watches[_nextId] = w
+	l.paths[w.dir] = append(l.paths[w.dir], _nextId)
+	return _nextId, nil
 }
 
-// Closes existing watch.
-func Close(id int) {
-	w, ok := _watches[id]
+// Adds path to shared watcher unless already watched.
+func (l *loop) notify(dir string) (err error) {
+	if l.watcher == nil {
+		l.watcher, err = newWatcher()
+		if err != nil {
+			l.watcher = nil
+			return
+		}
+	}
+
+	// Path may already be watched by another registration
+	if l.notified[dir] == 0 {
+		err = l.watcher.Add(dir)
+		if err != nil {
+			return
+		}
+	}
+	l.notified[dir]++
+	return
+}
+
+// Closes existing watch and drops its pending callbacks.
+// Path is removed from shared watcher when no other watch uses it.
+func (l *loop) close(id int) {
+	w, ok := l.watches[id]
 	if !ok {
 		return
 	}
 
 	//fmt.Println("[fwatch] closing watch:", id)
-	w.watcher.Close()
-	delete(_watches, id)
-}
+	delete(l.watches, id)
+	_count.Add(-1)
 
-// Go routine that waits for an change event and notifies via callback
-func watch(w *Watch) {
-	//fmt.Println("[fwatch] watching for changes in:", w.dir)
-WatchLoop:
-	for {
-		select {
-		case ev, ok := <-w.watcher.Events:
-			if !ok {
-				break WatchLoop
+	if c, ok := l.callbackers[w.id]; ok {
+		for i, cw := range c.watches {
+			if cw == w {
+				c.watches = append(c.watches[:i], c.watches[i+1:]...)
+				break
 			}
-			//fmt.Println("[fwatch] change", ev)
-			scheduleCallback(w, ev.Name)
+		}
+		if len(c.watches) == 0 && !c.running {
+			delete(l.callbackers, w.id)
+		}
+	}
 
-		case err, ok := <-w.watcher.Errors:
-			if !ok {
-				break WatchLoop
+	ids := l.paths[w.dir]
+	for i, wid := range ids {
+		if wid == id {
+			ids = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(ids) > 0 {
+		l.paths[w.dir] = ids
+	} else {
+		delete(l.paths, w.dir)
+	}
+
+	if w.poller != nil {
+		w.poller.stop()
+		return
+	}
+	l.notified[w.dir]--
+	if l.notified[w.dir] > 0 {
+		return
+	}
+	delete(l.notified, w.dir)
+	if l.watcher != nil {
+		l.watcher.Remove(w.dir)
+	}
+}
+
+// Schedules callbacks of watches interested in event.
+func (l *loop) dispatch(ev fsnotify.Event) {
+	for _, w := range l.lookup(ev.Name) {
+		if w.opts.accepts(ev, w.dir) {
+			l.scheduleCallback(w, ev.Name)
+		}
+	}
+}
+
+// Returns watches registered for event path.
+// Event path can be the watched path itself or an entry inside it.
+func (l *loop) lookup(name string) (ws []*Watch) {
+	name = filepath.Clean(name)
+	paths := []string{name}
+	if dir := filepath.Dir(name); dir != name {
+		paths = append(paths, dir)
+	}
+	for _, p := range paths {
+		for _, id := range l.paths[p] {
+			if w, ok := l.watches[id]; ok {
+				ws = append(ws, w)
 			}
-			fmt.Println("[fwatch] error:", err)
 		}
 	}
-	//fmt.Println("[fwatch] closed watch for:", w.dir)
+	return
 }
 
 // Event damper prevents from too many events firing at once.
 // Calls callback only after duration elapsed since last change event.
-func scheduleCallback(w *Watch, dir string) {
-	if w.timer == nil {
-		w.timer = time.NewTimer(w.tdelta)
-		go func() {
-			<-w.timer.C
-			//w.callback(w.id, w.id2)
-			queueCallback(w)
-			w.timer = nil
-		}()
+func (l *loop) scheduleCallback(w *Watch, name string) {
+	w.due = time.Now().Add(w.opts.FileDamper)
+	w.paths = appendPath(w.paths, filepath.Clean(name))
+}
 
-	} else {
-		w.timer.Reset(w.tdelta)
+// Queues watch callback with its repository callbacker.
+// Callback damper duration is taken from the latest queued watch.
+func (l *loop) queueCallback(w *Watch, now time.Time) {
+	c, ok := l.callbackers[w.id]
+	if !ok {
+		c = &Callbacker{}
+		l.callbackers[w.id] = c
+	}
+	for _, p := range w.paths {
+		c.paths = appendPath(c.paths, p)
+	}
+	w.paths = nil
+	for _, cw := range c.watches {
+		if cw == w {
+			c.due = now.Add(w.opts.CallbackDamper)
+			return
+		}
 	}
+	c.watches = append(c.watches, w)
+	c.due = now.Add(w.opts.CallbackDamper)
 }
 
-func queueCallback(w *Watch) {
-	_callbacker.watches = append(_callbacker.watches, w)
-	if _callbacker.timer == nil {
-		_callbacker.timer = time.NewTimer(_callbacker.tdelta)
-		go func() {
-			<-_callbacker.timer.C
-			_callbacker.execute()
-			_callbacker.timer = nil
-		}()
+// Moves elapsed watches to callbackers and
+// executes elapsed callbackers that are not running.
+func (l *loop) fire(now time.Time) {
+	for _, w := range l.watches {
+		if !w.due.IsZero() && !w.due.After(now) {
+			w.due = time.Time{}
+			l.queueCallback(w, now)
+		}
+	}
 
-	} else {
-		_callbacker.timer.Reset(_callbacker.tdelta)
+	for rid, c := range l.callbackers {
+		if c.running || len(c.watches) == 0 || c.due.After(now) {
+			continue
+		}
+		c.running = true
+		go c.execute(rid, c.watches, c.paths)
+		c.watches = nil
+		c.paths = nil
+	}
+}
+
+// Resets loop timer to nearest due time.
+func (l *loop) resetTimer() {
+	var next time.Time
+	for _, w := range l.watches {
+		if !w.due.IsZero() && (next.IsZero() || w.due.Before(next)) {
+			next = w.due
+		}
+	}
+	for _, c := range l.callbackers {
+		if c.running || len(c.watches) == 0 {
+			continue
+		}
+		if next.IsZero() || c.due.Before(next) {
+			next = c.due
+		}
+	}
+
+	if !l.timer.Stop() {
+		select {
+		case <-l.timer.C:
+		default:
+		}
+	}
+	if !next.IsZero() {
+		l.timer.Reset(time.Until(next))
+	}
+}
+
+//------------------------------------------------------------
+// Callbacker methods
+//------------------------------------------------------------
+
+// Executes callback once per repository, then reports to the loop.
+// Runs outside of the loop so callbacks may add or close watches.
+func (c *Callbacker) execute(rid string, watches []*Watch, paths []string) {
+	defer func() {
+		_done <- rid
+	}()
+	if len(watches) > 0 {
+		w := watches[0]
+		w.callback(w.id, w.id2, paths)
+	}
+}
+
+// Appends path unless already present.
+func appendPath(paths []string, p string) []string {
+	for _, pp := range paths {
+		if pp == p {
+			return paths
+		}
 	}
+	return append(paths, p)
 }
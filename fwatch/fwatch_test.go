@@ -0,0 +1,161 @@
+// Tester
+package fwatch
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+var testOptions = Options{
+	FileDamper:     50 * time.Millisecond,
+	CallbackDamper: 50 * time.Millisecond,
+}
+
+func TestWatchDir(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "com _ _")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls int32
+	var changed []string
+	callback := func(rid, rid2 string, paths []string) {
+		changed = paths
+		atomic.AddInt32(&calls, 1)
+	}
+
+	id1, err := WatchDirPaths(dir, "repo", "", callback, testOptions)
+	if err != nil {
+		t.Fatalf("Error adding watch: %s", err)
+	}
+	id2, err := WatchDirPaths(sub, "repo", "com _ _", callback, testOptions)
+	if err != nil {
+		t.Fatalf("Error adding watch: %s", err)
+	}
+	if n := Count(); n != 2 {
+		t.Errorf("Expected 2 active watches, got %d", n)
+	}
+
+	// Changes in both watches of same repository produce single callback
+	os.WriteFile(filepath.Join(sub, "info.ini"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(dir, "info.ini"), []byte("a"), 0644)
+	time.Sleep(400 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("Expected 1 callback, got %d", n)
+	}
+	if len(changed) != 2 {
+		t.Errorf("Expected 2 changed paths, got %v", changed)
+	}
+
+	// Closed watches produce no callbacks
+	CloseMany([]int{id1, id2})
+	os.WriteFile(filepath.Join(sub, "info.ini"), []byte("b"), 0644)
+	time.Sleep(300 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("Expected no callbacks after close, got %d", n-1)
+	}
+
+	// Event loop stops without watches
+	_mu.Lock()
+	running := _running
+	_mu.Unlock()
+	if running {
+		t.Errorf("Expected event loop to stop after all watches closed")
+	}
+	if n := Count(); n != 0 {
+		t.Errorf("Expected no active watches, got %d", n)
+	}
+}
+
+func TestAccepts(t *testing.T) {
+	dir := "/www/txt/com _ _"
+	def := Options{}.withDefaults()
+	ini := Options{Include: []string{"*.ini"}}.withDefaults()
+
+	tests := []struct {
+		opts Options
+		name string
+		op   fsnotify.Op
+		ok   bool
+	}{
+		{def, "info.ini", fsnotify.Write, true},
+		{def, "info.ini", fsnotify.Chmod, false},
+		{def, ".info.ini.swp", fsnotify.Create, false},
+		{def, "info.ini~", fsnotify.Write, false},
+		{def, ".#info.ini", fsnotify.Create, false},
+		{def, "4913", fsnotify.Create, false},
+		{def, ".DS_Store", fsnotify.Write, false},
+		{ini, "info.ini", fsnotify.Remove, true},
+		{ini, "info.html", fsnotify.Write, false},
+		{ini, "", fsnotify.Remove, true},
+	}
+
+	for _, tt := range tests {
+		ev := fsnotify.Event{Name: filepath.Join(dir, tt.name), Op: tt.op}
+		if ok := tt.opts.accepts(ev, dir); ok != tt.ok {
+			t.Errorf("accepts(%q, %s) = %v, expected %v", tt.name, tt.op, ok, tt.ok)
+		}
+	}
+}
+
+func TestPoll(t *testing.T) {
+	dir := t.TempDir()
+
+	var calls int32
+	opts := testOptions
+	opts.Poll = true
+	opts.PollInterval = 20 * time.Millisecond
+	id, err := WatchDir(dir, "polled", "", func(rid, rid2 string) {
+		atomic.AddInt32(&calls, 1)
+	}, opts)
+	if err != nil {
+		t.Fatalf("Error adding watch: %s", err)
+	}
+	defer Close(id)
+
+	os.WriteFile(filepath.Join(dir, "info.ini"), []byte("a"), 0644)
+	time.Sleep(400 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("Expected 1 callback, got %d", n)
+	}
+}
+
+func TestPollFallback(t *testing.T) {
+	newWatcher = func() (*fsnotify.Watcher, error) {
+		return nil, errors.New("too many open files")
+	}
+	defer func() { newWatcher = fsnotify.NewWatcher }()
+
+	dir := t.TempDir()
+	var calls int32
+	opts := testOptions
+	opts.PollInterval = 20 * time.Millisecond
+	var buf bytes.Buffer
+	opts.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+	id, err := WatchDir(dir, "fallback", "", func(rid, rid2 string) {
+		atomic.AddInt32(&calls, 1)
+	}, opts)
+	if err != nil {
+		t.Fatalf("Error adding watch: %s", err)
+	}
+	defer Close(id)
+	if !strings.Contains(buf.String(), "Falling back to polling") {
+		t.Errorf("Expected fallback warning, got %q", buf.String())
+	}
+
+	os.WriteFile(filepath.Join(dir, "info.ini"), []byte("a"), 0644)
+	time.Sleep(400 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("Expected 1 callback from polling fallback, got %d", n)
+	}
+}
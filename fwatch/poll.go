@@ -0,0 +1,158 @@
+// Polling watcher for filesystems without reliable fsnotify events
+
+package fwatch
+
+import (
+	"crypto/sha1"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+//------------------------------------------------------------
+// Poller
+//------------------------------------------------------------
+
+// Polls single path (directory or file) and emits
+// synthetic fsnotify events into the event loop.
+type poller struct {
+	path  string
+	opts  Options
+	state map[string]fileState
+	done  chan struct{}
+}
+
+// Polled file state
+type fileState struct {
+	size    int64
+	modTime time.Time
+	isDir   bool
+	hash    [sha1.Size]byte
+}
+
+// Starts polling path. Initial scan must succeed.
+func startPoller(path string, opts Options) (p *poller, err error) {
+	p = &poller{
+		path: path,
+		opts: opts,
+		done: make(chan struct{}),
+	}
+	p.state, err = p.scan()
+	if err != nil {
+		return nil, err
+	}
+	go p.run()
+	return
+}
+
+// Stops polling.
+func (p *poller) stop() {
+	close(p.done)
+}
+
+// Go routine that polls path on interval.
+func (p *poller) run() {
+	ticker := time.NewTicker(p.opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+
+		state, err := p.scan()
+		if err != nil {
+			// Watched path itself is gone
+			if len(p.state) > 0 {
+				p.state = map[string]fileState{}
+				p.emit(fsnotify.Event{Name: p.path, Op: fsnotify.Remove})
+			}
+			continue
+		}
+
+		for name, fs := range state {
+			old, ok := p.state[name]
+			switch {
+			case !ok:
+				p.emit(fsnotify.Event{Name: name, Op: fsnotify.Create})
+			case old != fs:
+				p.emit(fsnotify.Event{Name: name, Op: fsnotify.Write})
+			}
+		}
+		for name := range p.state {
+			if _, ok := state[name]; !ok {
+				p.emit(fsnotify.Event{Name: name, Op: fsnotify.Remove})
+			}
+		}
+		p.state = state
+	}
+}
+
+// Sends event to the event loop unless stopped.
+func (p *poller) emit(ev fsnotify.Event) {
+	select {
+	case _polled <- ev:
+	case <-p.done:
+	}
+}
+
+// Scans path, returning state of path and its direct entries.
+func (p *poller) scan() (state map[string]fileState, err error) {
+	var fi os.FileInfo
+	fi, err = os.Stat(p.path)
+	if err != nil {
+		return
+	}
+
+	state = map[string]fileState{}
+	if !fi.IsDir() {
+		state[p.path] = p.stat(p.path, fi)
+		return
+	}
+
+	var des []os.DirEntry
+	des, err = os.ReadDir(p.path)
+	if err != nil {
+		return
+	}
+	for _, de := range des {
+		name := filepath.Join(p.path, de.Name())
+		if fi, err := de.Info(); err == nil {
+			state[name] = p.stat(name, fi)
+		}
+	}
+	return
+}
+
+// Returns state of single file.
+func (p *poller) stat(name string, fi os.FileInfo) (fs fileState) {
+	fs = fileState{
+		size:    fi.Size(),
+		modTime: fi.ModTime(),
+		isDir:   fi.IsDir(),
+	}
+	if p.opts.PollHash && !fi.IsDir() {
+		fs.hash = hashFile(name)
+	}
+	return
+}
+
+// Returns file content hash, zero on error.
+func hashFile(name string) (sum [sha1.Size]byte) {
+	f, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	h := sha1.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return
+	}
+	copy(sum[:], h.Sum(nil))
+	return
+}
@@ -0,0 +1,234 @@
+// Activated snapshots history, rollback and validation
+package wiro
+
+import (
+    "archive/zip"
+    "fmt"
+    "io/fs"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// Default number of retained snapshots
+const defaultHistory = 3
+
+//------------------------------------------------------------
+// Snapshot
+//------------------------------------------------------------
+
+// Activated repository content. Generation increases with each
+// activation, Event describes reload that activated the snapshot
+// (empty for initial load) and is set once reload completes.
+// Snapshot content never changes, pin it with Pin or Repo.Snapshot
+// to get consistent lookups across hot swaps.
+type Snapshot struct {
+    Generation int
+    Resources  map[string][]*Resource
+    Event      ReloadEvent
+    Time       time.Time
+    inherited  map[*Resource][]string
+    // Repository of activated snapshot, lookups are recorded to metrics
+    repoId     string
+    // Normalizer of lookup keys, as of key directories
+    norm       *normalizer
+    // Source resources are read from, see Open
+    fsys       fs.FS
+    // Zip bundle resources are read from, kept open while retained
+    bundle     *zip.ReadCloser
+    // Resolution index and memoized fallbacks
+    indexOnce  sync.Once
+    index      map[string]map[variant]*Resource
+    resolved   sync.Map
+    nResolved  atomic.Int32
+}
+
+//------------------------------------------------------------
+// Exported functions
+//------------------------------------------------------------
+
+// Returns retained snapshots of repository, oldest first.
+// Last one is active unless repository was rolled back.
+func History(repoId string) (snaps []*Snapshot, err error) {
+    repo, ok := getRepo(repoId)
+    if !ok {
+        return nil, fmt.Errorf("Repository not found: %s", repoId)
+    }
+
+    repo.reloadMu.Lock()
+    defer repo.reloadMu.Unlock()
+    return append(snaps, repo.history...), nil
+}
+
+// Reactivates retained snapshot of given generation and pauses
+// automatic reloading until Resume or Reload activates new content.
+// Reload listeners are notified.
+func Rollback(repoId string, generation int) (ev ReloadEvent, err error) {
+    repo, ok := getRepo(repoId)
+    if !ok {
+        err = fmt.Errorf("Repository not found: %s", repoId)
+        return
+    }
+
+    repo.reloadMu.Lock()
+    defer repo.reloadMu.Unlock()
+
+    if repo.closed {
+        err = fmt.Errorf("Repository closed: %s", repoId)
+        return
+    }
+
+    var snap *Snapshot
+    for _, s := range repo.history {
+        if s.Generation == generation {
+            snap = s
+        }
+    }
+    if snap == nil {
+        err = fmt.Errorf("Snapshot generation %d not retained: %s", generation, repoId)
+        return
+    }
+
+    ev = ReloadEvent{
+        RepoId: repo.Id,
+        Generation: snap.Generation,
+        Rollback: true,
+        Time: time.Now(),
+    }
+    ev.diff(repo.Resources, snap.Resources)
+
+    repo.mu.Lock()
+    repo.paused = true
+    repo.mu.Unlock()
+    repo.current.Store(snap)
+    repo.Resources = snap.Resources
+    ev.Duration = time.Since(ev.Time)
+
+    repo.notify(ev)
+    return
+}
+
+// Resumes automatic reloading paused by Rollback
+// and reloads repository to pick up latest content.
+func Resume(repoId string) (ev ReloadEvent, err error) {
+    repo, ok := getRepo(repoId)
+    if !ok {
+        err = fmt.Errorf("Repository not found: %s", repoId)
+        return
+    }
+
+    repo.mu.Lock()
+    repo.paused = false
+    repo.mu.Unlock()
+    return repo.reload(nil)
+}
+
+//------------------------------------------------------------
+// Repository methods
+//------------------------------------------------------------
+
+// Makes resources active as new snapshot generation
+// and adds it to history. Event of reload in progress is
+// completed and stored in snapshot before it is published.
+func (r *Repo) activate(resources map[string][]*Resource, inherited map[*Resource][]string) {
+    snap := &Snapshot{
+        Generation: r.latestGeneration() + 1,
+        Resources: resources,
+        Time: time.Now(),
+        inherited: inherited,
+        repoId: r.Id,
+        norm: r.norm,
+        fsys: r.fsys,
+        bundle: r.bundle,
+    }
+    if ev := r.reloadEv; ev != nil {
+        ev.Generation = snap.Generation
+        ev.Duration = time.Since(ev.Time)
+        ev.Errors = r.loadErrs
+        ev.diff(r.Resources, resources)
+        snap.Event = *ev
+    }
+    snap.indexed()
+    r.current.Store(snap)
+    r.Resources = resources
+
+    n := r.opts.History
+    if n == 0 {
+        n = defaultHistory
+    }
+    if n < 1 {
+        n = 1
+    }
+    r.history = append(r.history, snap)
+    if len(r.history) > n {
+        dropped := r.history[:len(r.history) - n]
+        r.history = append([]*Snapshot{}, r.history[len(r.history) - n:]...)
+        r.releaseBundles(dropped)
+    }
+}
+
+// Closes bundles of snapshots dropped from history
+// unless still used by retained snapshots or for loading.
+func (r *Repo) releaseBundles(dropped []*Snapshot) {
+    used := map[*zip.ReadCloser]bool{r.bundle: true}
+    for _, s := range r.history {
+        used[s.bundle] = true
+    }
+    for _, s := range dropped {
+        if s.bundle != nil && !used[s.bundle] {
+            s.bundle.Close()
+            used[s.bundle] = true
+        }
+    }
+}
+
+// Returns latest activated generation, which is not
+// the active one after rollback.
+func (r *Repo) latestGeneration() int {
+    if len(r.history) == 0 {
+        return 0
+    }
+    return r.history[len(r.history) - 1].Generation
+}
+
+// Validates overlaid temporary resources and candidate snapshot.
+// Validation errors are added to load errors.
+func (r *Repo) validateTemp() (err error) {
+    var errs []error
+    for id, rsrcs := range r.resources {
+        for _, rsrc := range rsrcs {
+            if v, ok := (*rsrc).(Validator); ok {
+                if verr := v.Validate(); verr != nil {
+                    k := (*rsrc).GetKey()
+                    errs = append(errs, fmt.Errorf("%s [%s %s %s]: %w", id, k.Domain, k.Language, k.Version, verr))
+                }
+            }
+        }
+    }
+
+    if len(errs) == 0 && r.opts.Validate != nil {
+        candidate := &Snapshot{
+            Generation: r.latestGeneration() + 1,
+            Resources: r.resources,
+            norm: r.loadNorm,
+            Time: time.Now(),
+            inherited: r.inherited,
+        }
+        if verr := r.opts.Validate(candidate); verr != nil {
+            errs = append(errs, fmt.Errorf("repository validation: %w", verr))
+        }
+    }
+
+    if len(errs) > 0 {
+        r.loadErrs = append(r.loadErrs, errs...)
+        err = fmt.Errorf("Validation failed with %d errors: %s", len(errs), r.Id)
+        r.log().Warn("Content rejected", "errors", len(errs), "err", errs[0])
+    }
+    return
+}
+
+func (r *Repo) isPaused() bool {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return r.paused
+}
@@ -0,0 +1,59 @@
+// Resolution index
+package wiro
+
+//------------------------------------------------------------
+// Index
+//------------------------------------------------------------
+
+// Maximum number of memoized fallback results per snapshot,
+// lookups beyond it resolve through fallback steps each time.
+const maxResolved = 4096
+
+// Memoized lookup
+type lookupKey struct {
+    id string
+    variant
+}
+
+// Memoized fallback result, step 0 is a miss
+type resolved struct {
+    rsrc *Resource
+    step int
+}
+
+// Returns resources by id and variant. Index is built once,
+// at activation for activated snapshots. First of duplicate
+// variants wins, as with sequential scan.
+func (s *Snapshot) indexed() map[string]map[variant]*Resource {
+    s.indexOnce.Do(func() {
+        s.index = make(map[string]map[variant]*Resource, len(s.Resources))
+        for id, rsrcs := range s.Resources {
+            variants := make(map[variant]*Resource, len(rsrcs))
+            for _, rsrc := range rsrcs {
+                v := variantOf((*rsrc).GetKey())
+                if _, dup := variants[v]; !dup {
+                    variants[v] = rsrc
+                }
+            }
+            s.index[id] = variants
+        }
+    })
+    return s.index
+}
+
+// Resolves requested variant through fallback steps,
+// memoizing result for following lookups.
+func (s *Snapshot) fallback(id string, variants map[variant]*Resource, want variant) (rsrc *Resource, step int) {
+    lk := lookupKey{id, want}
+    if res, ok := s.resolved.Load(lk); ok {
+        return res.(resolved).rsrc, res.(resolved).step
+    }
+
+    rsrc, step = resolveSteps(variants, want, nil)
+    if s.nResolved.Load() < maxResolved {
+        if _, loaded := s.resolved.LoadOrStore(lk, resolved{rsrc, step}); !loaded {
+            s.nResolved.Add(1)
+        }
+    }
+    return
+}
@@ -0,0 +1,159 @@
+// Repository tree linter
+package wiro
+
+import (
+    "fmt"
+    "io/fs"
+    "os"
+    "sort"
+    "strings"
+)
+
+//------------------------------------------------------------
+// Lint problems
+//------------------------------------------------------------
+
+// Problem found in repository tree.
+// Path is key directory or file inside repository root.
+type LintProblem struct {
+    Path    string
+    Message string
+}
+
+func (p LintProblem) String() string {
+    return p.Path + ": " + p.Message
+}
+
+//------------------------------------------------------------
+// Exported functions
+//------------------------------------------------------------
+
+// Checks repository tree in dir without loading it:
+// key directory names, duplicate keys after normalization
+// with aliases, files outside key directories, files no parser
+// claims, and parse errors. aliases is alias file name as in
+// Options.Aliases, empty means DefaultAliasFile.
+// Returns problems sorted by path.
+func Lint(dir string, parsers *ParserLib, aliases string) (problems []LintProblem, err error) {
+    return lint(dir, os.DirFS(dir), parseFuncs(parsers, nil), aliases)
+}
+
+// Checks repository tree in file system fsys with reader parsers,
+// as Lint does.
+func LintFS(fsys fs.FS, readers *ReaderParserLib, aliases string) (problems []LintProblem, err error) {
+    return lint("", fsys, parseFuncs(nil, readers), aliases)
+}
+
+//------------------------------------------------------------
+// Linter
+//------------------------------------------------------------
+
+func lint(dir string, fsys fs.FS, parsers map[string]parseFunc, aliases string) (problems []LintProblem, err error) {
+    var des []fs.DirEntry
+    des, err = fs.ReadDir(fsys, ".")
+    if err != nil {
+        return
+    }
+
+    report := func(p, format string, args ...interface{}) {
+        problems = append(problems, LintProblem{Path: p, Message: fmt.Sprintf(format, args...)})
+    }
+
+    if aliases == "" {
+        aliases = DefaultAliasFile
+    }
+    norm, _, aerr := loadAliases(fsys, aliases)
+    if aerr != nil {
+        report(aliases, "%s", aerr)
+    }
+
+    // Key directories by normalized key
+    keys := map[variant]string{}
+    for _, de := range des {
+        subdir := de.Name()
+        if strings.HasPrefix(subdir, ".") {
+            continue
+        }
+        if !de.IsDir() {
+            if subdir != aliases {
+                report(subdir, "file outside key directory")
+            }
+            continue
+        }
+
+        domain, lang, ver, e := parseDirName(subdir)
+        if e != nil {
+            report(subdir, "invalid key directory name, expected '<domain> <language> <version>'")
+            continue
+        }
+        v := variant{}
+        v.domain, v.language, v.version = norm.normalize(domain, lang, ver)
+        if other, ok := keys[v]; ok {
+            report(subdir, "duplicates key directory %q", other)
+            continue
+        }
+        keys[v] = subdir
+
+        lintKeyDir(dir, fsys, subdir, parsers, report)
+    }
+
+    sort.SliceStable(problems, func(i, j int) bool {
+        return problems[i].Path < problems[j].Path
+    })
+    return
+}
+
+// Checks files of key directory: every file must be claimed
+// by parser and parse without errors.
+func lintKeyDir(dir string, fsys fs.FS, subdir string, parsers map[string]parseFunc,
+    report func(p, format string, args ...interface{})) {
+
+    domain, lang, ver, _ := parseDirName(subdir)
+    fs.WalkDir(fsys, subdir, func(name string, de fs.DirEntry, err error) error {
+        if err != nil {
+            report(name, "%s", err)
+            return nil
+        }
+        if name != subdir && strings.HasPrefix(de.Name(), ".") {
+            if de.IsDir() {
+                return fs.SkipDir
+            }
+            return nil
+        }
+        if de.IsDir() {
+            return nil
+        }
+
+        f := strings.TrimPrefix(name, subdir + "/")
+        parser, ok := parsers[f]
+        if !ok {
+            report(name, "no parser for file")
+            return nil
+        }
+        if parser == nil {
+            return nil
+        }
+
+        fi, err := de.Info()
+        if err != nil {
+            report(name, "%s", err)
+            return nil
+        }
+        key := Key{
+            Id: f,
+            File: filePath(dir, name),
+            Path: name,
+            ModTime: fi.ModTime(),
+            Domain: domain,
+            Language: lang,
+            Version: ver,
+        }
+        obj, err := parser(key, fsys, name)
+        if err != nil {
+            report(name, "parse error: %s", err)
+        } else if _, ok = obj.(Resource); !ok {
+            report(name, "parser returned %T, not Resource", obj)
+        }
+        return nil
+    })
+}
@@ -2,13 +2,15 @@
 package wiro
 
 import (
+    "archive/zip"
     "fmt"
-    "io/ioutil"
+    "io/fs"
     "os"
     "path"
     "path/filepath"
     "regexp"
     "strconv"
+    "time"
     "github.com/deze333/wiro/fwatch"
 )
 
@@ -33,12 +35,26 @@ var reDataDir = regexp.MustCompile(`^(?P<domain>_|[^\s]+)\s(?P<lang>_|[^\s]+)\s(
 // Loads directories residing in given root directory.
 // Subdirectories can have inner directories.
 func load(repo *Repo) (err error) {
+
+    // Try fast start from snapshot, parse on any problem
+    if repo.opts.Snapshot != "" && repo.Zip == "" {
+        if err = loadSnapshot(repo, repo.opts.Snapshot); err == nil {
+            return
+        }
+        repo.log().Info("Snapshot not used, parsing repository", "err", err)
+    }
     
     err = loadRoot(repo)
     if err != nil {
         return
     }
 
+    if repo.opts.Snapshot != "" && repo.Zip == "" {
+        if err := repo.saveSnapshot(repo.opts.Snapshot); err != nil {
+            repo.log().Warn("Error saving snapshot", "file", repo.opts.Snapshot, "err", err)
+        }
+    }
+
     // Watch root directory for changes.
     // No need to remember watcher id as root doesn't change
     // _, err = fwatch.WatchDir(dir, reloadRoot)
@@ -50,16 +66,48 @@ func load(repo *Repo) (err error) {
 //------------------------------------------------------------
 
 func loadRoot(repo *Repo) (err error) {
+    repo.loadErrs = nil
+    repo.loadFiles = map[string]time.Time{}
+
+    // Open new bundle, active one stays open until swap
+    fsys := repo.fsys
+    var bundle *zip.ReadCloser
+    if repo.Zip != "" {
+        bundle, err = openBundle(repo)
+        if err != nil {
+            return
+        }
+        defer func() {
+            if err != nil {
+                bundle.Close()
+            }
+        }()
+        fsys = bundle
+    }
+
     // Discover all subdirectories
-    var fis []os.FileInfo
-    fis, err = ioutil.ReadDir(repo.Dir)
+    var fis []fs.DirEntry
+    fis, err = fs.ReadDir(fsys, ".")
     if err != nil {
-        SOS("loadRoot", "Error reading directory", "err", err, "dir", repo.Dir)
+        repo.log().Error("Error reading directory", "dir", repo.Dir, "err", err)
+        repo.loadErrs = append(repo.loadErrs, err)
         return
     }
 
-    // XXX: Stop all old watches
-    fwatch.CloseMany(repo.WatchIds)
+    // Load key normalization, previous stays on error
+    norm, normErr := repo.loadNormalizer(fsys)
+    if normErr != nil {
+        repo.log().Error("Error loading aliases", "file", repo.aliasFile(), "err", normErr)
+        repo.loadErrs = append(repo.loadErrs, normErr)
+        norm = repo.norm
+    }
+    repo.loadNorm = norm
+
+    // XXX: Stop all old watches, bundle watch never changes
+    if bundle == nil {
+        fwatch.CloseMany(repo.WatchIds)
+        repo.setWatchIds([]int{})
+    }
 
     // Map of watched subdirs (dir:key)
     watches := map[string]string{}
@@ -67,8 +115,7 @@ func loadRoot(repo *Repo) (err error) {
     // Load each key subdirectory
     for _, fi := range fis {
         if fi.IsDir() {
-            d := path.Join(repo.Dir, fi.Name())
-            subdirs := loadKeyDir(repo, d, fi.Name())
+            subdirs := loadKeyDir(repo, fsys, fi.Name())
             // XXX Add to watched
             for sd, _ := range subdirs {
                 watches[path.Join(repo.Dir, fi.Name(), sd)] = path.Join(fi.Name(), sd)
@@ -76,72 +123,151 @@ func loadRoot(repo *Repo) (err error) {
         }
     }
 
-    // Activate this repository
-    repo.hotSwapAll()
+    // Overlay and validate new content
+    repo.overlayTemp()
+    err = repo.validateTemp()
+    if err == nil {
+        err = normErr
+    }
+
+    // Bundle is activated only if loaded without errors
+    if err == nil && bundle != nil {
+        err = swapBundle(repo, bundle)
+    }
+
+    // Activate this repository unless rejected
+    if err != nil {
+        repo.resources = map[string][]*Resource{}
+    } else {
+        repo.norm = repo.loadNorm
+        repo.hotSwapAll()
+        repo.files = repo.loadFiles
+    }
     //repo.dump()
 
+    // Watch root and key subdirectories unless disabled
+    if !repo.opts.NoWatch {
+        repo.watch(watches)
+    }
+    return
+}
+
+// Adds watches for repository root and its key subdirectories.
+// watches maps subdirectory full path to its path inside Repo.Dir.
+func (repo *Repo) watch(watches map[string]string) {
+    if repo.Zip != "" {
+        repo.watchBundle()
+        return
+    }
+
     // XXX Add root to watched
-    var id int
-    id, err = fwatch.WatchDir(repo.Dir, repo.Id, "", onDirChanged)
+    ids := repo.WatchIds
+    opts := repo.watchOptions()
+    id, err := fwatch.WatchDirPaths(repo.Dir, repo.Id, "", onDirChanged, opts)
     if err != nil {
-        SOS("loadRoot", "Error adding watch", "dir", repo.Dir, "err", err)
+        repo.log().Error("Error adding watch", "dir", repo.Dir, "err", err)
     } else {
-        repo.WatchIds = append(repo.WatchIds, id)
+        ids = append(ids, id)
     }
 
     // XXX Add root subdirs to watched
     for dir, subdir := range watches {
         //NOTE("WATCH", "d", dir, "key", subdir)
-        id, err = fwatch.WatchDir(dir, repo.Id, subdir, onDirChanged)
+        id, err = fwatch.WatchDirPaths(dir, repo.Id, subdir, onDirChanged, opts)
         if err != nil {
-            SOS("loadRoot", "Error adding watch", "dir", dir, "err", err)
+            repo.log().Error("Error adding watch", "keydir", subdir, "dir", dir, "err", err)
         } else {
-            repo.WatchIds = append(repo.WatchIds, id)
+            ids = append(ids, id)
         }
     }
+    repo.setWatchIds(ids)
+}
 
+// Replaces watch ids. Called with reloadMu held, ids are also
+// guarded by mu for readers not holding reloadMu (ie, debug view).
+func (repo *Repo) setWatchIds(ids []int) {
+    repo.mu.Lock()
+    repo.WatchIds = ids
+    repo.mu.Unlock()
+}
+
+// Returns watch options, watches log to repository logger
+// unless watch logger is set.
+func (repo *Repo) watchOptions() (opts fwatch.Options) {
+    opts = repo.opts.Watch
+    if opts.Logger == nil {
+        opts.Logger = repo.log()
+    }
     return
 }
 
 // Callback on root directory changed.
 // id is the repository id (ie, text),
-// id2 is key subdirectory inside Repo.Dir.
-func onDirChanged(id, id2 string) {
-    DEBUG("onDirChanged", "Reloading repository", "id", id)
+// id2 is key subdirectory inside Repo.Dir,
+// paths are changed files and directories.
+func onDirChanged(id, id2 string, paths []string) {
     //NOTE2("Reloading repository", id)
     if repo, ok := getRepo(id); ok {
-        if id2 == "" {
-            //NOTE("Reloading repo root", "id", repo.Id)
-            loadRoot(repo)
-        } else {
-            //NOTE("Reloading repo subdir", "id", repo.Id, "subdir", id2)
-            loadRoot(repo)
-            /* XXX Too complicated, reload the whole repo instead.
-            var subdir = id2
-            for id2 != "." {
-                subdir = id2
-                id2, _ = path.Split(id2)
-                id2 = path.Clean(id2)
-            }
-            loadKeyDir(repo, path.Join(repo.Dir, subdir), subdir)
-            repo.hotSwap(???)
-            */
+        repo.log().Debug("Reloading repository", "keydir", id2, "paths", paths)
+        if repo.isPaused() {
+            repo.log().Info("Auto reload paused after rollback, skipping")
+            return
         }
+        // XXX Reloading only subdir id2 is too complicated,
+        // reload the whole repo instead.
+        repo.reload(paths)
+    }
+    return
+}
 
-        // Notify optional onReload listener
-        if repo.onReload != nil {
-            go repo.onReload()
-        }
+// Reloads repository, hot swaps it and notifies reload listeners.
+// Reloads of same repository never run concurrently.
+// paths are changed files that triggered reload, if known.
+func (repo *Repo) reload(paths []string) (ev ReloadEvent, err error) {
+    repo.reloadMu.Lock()
+    defer repo.reloadMu.Unlock()
+
+    if repo.closed {
+        err = fmt.Errorf("Repository closed: %s", repo.Id)
+        return
+    }
+
+    ev = ReloadEvent{
+        RepoId: repo.Id,
+        Paths: paths,
+        Time: time.Now(),
+    }
+
+    // Event is completed at activation, before snapshot is published
+    repo.reloadEv = &ev
+    err = loadRoot(repo)
+    repo.reloadEv = nil
+
+    ev.Rejected = err != nil
+    if ev.Rejected {
+        ev.Duration = time.Since(ev.Time)
+        ev.Errors = repo.loadErrs
     }
+    recordReload(repo.Id, ev.Duration, ev.Rejected)
+
+    // Latest content is live again, rollback is over
+    if !ev.Rejected {
+        repo.mu.Lock()
+        repo.paused = false
+        repo.mu.Unlock()
+    }
+
+    // Notify reload listeners
+    repo.notify(ev)
     return
 }
 
 // Loads single key directory (ie, `com de 50%`)
 // and adds to temporary repository.
-// dir is key directory full path (ie, /home/alex/www/txt/_ _ _),
-// subdir is last part of dir (ie, _ _ _)
+// fsys is repository source being loaded,
+// subdir is key directory inside it (ie, _ _ _)
 // Returns map of directories to watch.
-func loadKeyDir(repo *Repo, dir, subdir string) (subdirs map[string]bool) {
+func loadKeyDir(repo *Repo, fsys fs.FS, subdir string) (subdirs map[string]bool) {
     //NOTE2("Parsing key", subdir)
     var err error
 
@@ -149,54 +275,58 @@ func loadKeyDir(repo *Repo, dir, subdir string) (subdirs map[string]bool) {
     var domain, lang, ver string
     domain, lang, ver, err = parseDirName(subdir)
     if err != nil {
-        WARNING("loadKeyDir", "Skipping directory", "dir", subdir, "err", err)
+        repo.log().Warn("Skipping directory", "keydir", subdir, "err", err)
         return
     }
+    domain, lang, ver = repo.loadNorm.normalize(domain, lang, ver)
 
     subdirs = map[string]bool{}
     subdirs["."] = true
+    repo.loadFiles[subdir] = time.Time{}
 
     // Parse each file with its parser
     var key Key
-    var fi os.FileInfo
+    var fi fs.FileInfo
     var resource Resource
     var ok bool
     var obj interface{}
 
-    for f, parser := range *(repo.Parsers) {
+    for f, parser := range parseFuncs(repo.Parsers, repo.Readers) {
         if parser == nil {
             continue
         }
         //NOTE("Parsing", "subdir", subdir , "file", f)
 
         // Check if file exists in this key directory
-        fpath := path.Join(dir, f)
-        if fi, err = os.Stat(fpath); os.IsNotExist(err) {
+        name := path.Join(subdir, f)
+        if fi, err = fs.Stat(fsys, name); err != nil {
             continue
         }
 
         // Parse and add resource
         key = Key{
             Id: f,
-            File: fpath,
+            File: filePath(repo.Dir, name),
+            Path: name,
             ModTime: fi.ModTime(),
             Domain: domain,
             Language: lang,
             Version: ver,
         }
-        obj, err = parser(key)
+        repo.loadFiles[name] = fi.ModTime()
+        obj, err = parser(key, fsys, name)
         if err != nil {
-            SOS("loadKeyDir", 
-            "Error parsing resource, file skipped", 
-            "dir", subdir, "file", f,
-            "err", err)
+            repo.log().Error("Error parsing resource, file skipped",
+                "keydir", subdir, "file", f, "err", err)
+            repo.loadErrs = append(repo.loadErrs, fmt.Errorf("%s/%s: %w", subdir, f, err))
+            recordParseError(repo.Id, key.Path)
             continue
         }
         if resource, ok = obj.(Resource); !ok {
-            SOS("loadKeyDir", 
-            "Returned struct is not of type Resource, file skipped",
-            "dir", subdir, "file", f,
-            "err", err)
+            repo.log().Error("Returned struct is not of type Resource, file skipped",
+                "keydir", subdir, "file", f, "type", fmt.Sprintf("%T", obj))
+            repo.loadErrs = append(repo.loadErrs, fmt.Errorf("%s/%s: parser returned %T, not Resource", subdir, f, obj))
+            recordParseError(repo.Id, key.Path)
             continue
         }
         repo.addTemp(&key, resource)
@@ -205,6 +335,15 @@ func loadKeyDir(repo *Repo, dir, subdir string) (subdirs map[string]bool) {
     return
 }
 
+// Returns full path of file inside repository directory,
+// empty for repositories without directory.
+func filePath(dir, name string) string {
+    if dir == "" {
+        return ""
+    }
+    return path.Join(dir, name)
+}
+
 //------------------------------------------------------------
 // Directory name parsing
 //------------------------------------------------------------
@@ -0,0 +1,73 @@
+// Lookup and reload metrics
+package wiro
+
+import (
+    "sync/atomic"
+    "time"
+)
+
+//------------------------------------------------------------
+// Metrics interface
+//------------------------------------------------------------
+
+// Receives lookup and reload measurements of all repositories.
+// Methods are called on lookup and reload paths and must be
+// fast and safe for concurrent use.
+type Metrics interface {
+    // Resource lookup, step is fallback step that satisfied it
+    // (1 exact ... 5 default), 0 if resource or repository
+    // was not found.
+    Lookup(repoId, id string, step int)
+    // Repository reload, rejected if content was not activated.
+    Reload(repoId string, d time.Duration, rejected bool)
+    // Resource file that failed to parse.
+    ParseError(repoId, file string)
+}
+
+// Active metrics, nil disables
+var _metrics atomic.Pointer[Metrics]
+
+//------------------------------------------------------------
+// Exported functions
+//------------------------------------------------------------
+
+// Replaces metrics receiver, nil disables metrics.
+// Metrics are disabled by default, see package expvarmetrics
+// for publishing them via expvar.
+func SetMetrics(m Metrics) {
+    if m == nil {
+        _metrics.Store(nil)
+        return
+    }
+    _metrics.Store(&m)
+}
+
+// Returns fallback step name, "miss" for step 0.
+func StepName(step int) string {
+    if step < 1 || step > len(fallbackSteps) {
+        return "miss"
+    }
+    return fallbackSteps[step - 1].name
+}
+
+//------------------------------------------------------------
+// Recording
+//------------------------------------------------------------
+
+func recordLookup(repoId, id string, step int) {
+    if m := _metrics.Load(); m != nil {
+        (*m).Lookup(repoId, id, step)
+    }
+}
+
+func recordReload(repoId string, d time.Duration, rejected bool) {
+    if m := _metrics.Load(); m != nil {
+        (*m).Reload(repoId, d, rejected)
+    }
+}
+
+func recordParseError(repoId, file string) {
+    if m := _metrics.Load(); m != nil {
+        (*m).ParseError(repoId, file)
+    }
+}
@@ -0,0 +1,69 @@
+// Unresolved lookups
+package wiro
+
+import (
+    "fmt"
+    "sync/atomic"
+)
+
+// Called when lookup finds no resource, because repository
+// or resource is unknown or no variant matched. key holds requested
+// resource id, domain, language and version. Returned resource,
+// unless nil, is returned to caller instead.
+type MissFunc func(repoId string, key Key) *Resource
+
+// Library miss hook, used for unknown repositories
+// and repositories without own hook
+var _onMiss atomic.Pointer[MissFunc]
+
+//------------------------------------------------------------
+// Exported functions
+//------------------------------------------------------------
+
+// Sets repository miss hook, replacing previously set one.
+// Nil removes it, library hook is then used.
+func SetOnMiss(repoId string, fn MissFunc) (err error) {
+    repo, ok := getRepo(repoId)
+    if !ok {
+        return fmt.Errorf("Repository not found: %s", repoId)
+    }
+    repo.mu.Lock()
+    repo.onMiss = fn
+    repo.mu.Unlock()
+    return
+}
+
+// Sets library miss hook, called for unknown repositories
+// and repositories without own hook. Nil removes it.
+func SetDefaultOnMiss(fn MissFunc) {
+    if fn == nil {
+        _onMiss.Store(nil)
+        return
+    }
+    _onMiss.Store(&fn)
+}
+
+//------------------------------------------------------------
+// Not exported functions
+//------------------------------------------------------------
+
+// Calls miss hook of repository, or library hook if repository
+// is nil or has no hook. Hook is called without locks held
+// so it can delegate to other repositories.
+func miss(repo *Repo, repoId, id string, domain, language, version string) *Resource {
+    var fn MissFunc
+    if repo != nil {
+        repo.mu.Lock()
+        fn = repo.onMiss
+        repo.mu.Unlock()
+    }
+    if fn == nil {
+        if p := _onMiss.Load(); p != nil {
+            fn = *p
+        }
+    }
+    if fn == nil {
+        return nil
+    }
+    return fn(repoId, Key{Id: id, Domain: domain, Language: language, Version: version})
+}
@@ -0,0 +1,281 @@
+// Key normalization and alias tables
+package wiro
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "io/fs"
+    "strings"
+    "time"
+)
+
+// Default alias table file in repository root.
+//
+// Aliases map domain, language or version to canonical one,
+// grouped by section. Both sides are normalized first:
+//
+//    # Domains
+//    [domain]
+//    co.uk = uk
+//
+//    [language]
+//    iw = he
+//
+//    [version]
+//    BigBanner = big-banner
+const DefaultAliasFile = "aliases.ini"
+
+//------------------------------------------------------------
+// Normalizer
+//------------------------------------------------------------
+
+// Normalizes key directory names and lookup keys: domains
+// and versions are case folded, www. is trimmed from domains,
+// languages are formatted as language tags (ie, en_US is en-US),
+// then aliases are applied. Nil normalizer leaves keys as is.
+type normalizer struct {
+    domains   map[string]string
+    languages map[string]string
+    versions  map[string]string
+}
+
+func newNormalizer() *normalizer {
+    return &normalizer{
+        domains: map[string]string{},
+        languages: map[string]string{},
+        versions: map[string]string{},
+    }
+}
+
+// Returns normalized domain, language and version.
+func (n *normalizer) normalize(domain, language, version string) (string, string, string) {
+    if n == nil {
+        return domain, language, version
+    }
+    domain = normalDomain(domain)
+    if alias, ok := n.domains[domain]; ok {
+        domain = alias
+    }
+    language = normalLanguage(language)
+    if alias, ok := n.languages[language]; ok {
+        language = alias
+    }
+    version = normalVersion(version)
+    if alias, ok := n.versions[version]; ok {
+        version = alias
+    }
+    return domain, language, version
+}
+
+//------------------------------------------------------------
+// Alias table loading
+//------------------------------------------------------------
+
+// Returns normalizer of repository being loaded, nil if
+// normalization is disabled. Alias file, if present, is recorded
+// to loaded files so its changes reload repository.
+func (repo *Repo) loadNormalizer(fsys fs.FS) (n *normalizer, err error) {
+    if repo.opts.NoNormalize {
+        return
+    }
+    var modTime time.Time
+    n, modTime, err = loadAliases(fsys, repo.aliasFile())
+    if err == nil && !modTime.IsZero() {
+        repo.loadFiles[repo.aliasFile()] = modTime
+    }
+    return
+}
+
+// Returns alias file name in repository root.
+func (repo *Repo) aliasFile() string {
+    if repo.opts.Aliases != "" {
+        return repo.opts.Aliases
+    }
+    return DefaultAliasFile
+}
+
+// Loads alias table from file in root of fsys.
+// Missing file means no aliases and zero modTime.
+func loadAliases(fsys fs.FS, name string) (n *normalizer, modTime time.Time, err error) {
+    n = newNormalizer()
+
+    f, err := fsys.Open(name)
+    if errors.Is(err, fs.ErrNotExist) {
+        return n, modTime, nil
+    }
+    if err != nil {
+        return
+    }
+    defer f.Close()
+
+    var fi fs.FileInfo
+    if fi, err = f.Stat(); err != nil {
+        return
+    }
+    modTime = fi.ModTime()
+
+    var table map[string]string
+    var part func(string) string
+    scanner := bufio.NewScanner(f)
+    for line := 1; scanner.Scan(); line++ {
+        s := strings.TrimSpace(scanner.Text())
+        if s == "" || strings.HasPrefix(s, "#") || strings.HasPrefix(s, ";") {
+            continue
+        }
+
+        // Section selects alias table
+        if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+            switch strings.ToLower(strings.TrimSpace(s[1:len(s) - 1])) {
+            case "domain":
+                table, part = n.domains, normalDomain
+            case "language":
+                table, part = n.languages, normalLanguage
+            case "version":
+                table, part = n.versions, normalVersion
+            default:
+                err = fmt.Errorf("%s:%d: unknown section %s, expected [domain], [language] or [version]", name, line, s)
+                return
+            }
+            continue
+        }
+
+        i := strings.Index(s, "=")
+        if i < 0 || table == nil {
+            err = fmt.Errorf("%s:%d: expected 'alias = name' in section", name, line)
+            return
+        }
+        alias := part(strings.TrimSpace(s[:i]))
+        canonical := part(strings.TrimSpace(s[i + 1:]))
+        if alias == "" || canonical == "" {
+            err = fmt.Errorf("%s:%d: empty alias or name", name, line)
+            return
+        }
+        table[alias] = canonical
+    }
+    err = scanner.Err()
+    return
+}
+
+//------------------------------------------------------------
+// Key parts
+//------------------------------------------------------------
+
+// Case folds domain and trims www. prefix.
+func normalDomain(s string) string {
+    return strings.TrimPrefix(foldCase(s), "www.")
+}
+
+// Case folds version.
+func normalVersion(s string) string {
+    return foldCase(s)
+}
+
+// Lower cases string, allocating only if it has upper case
+// or non ASCII characters.
+func foldCase(s string) string {
+    for i := 0; i < len(s); i++ {
+        if c := s[i]; 'A' <= c && c <= 'Z' || c >= 0x80 {
+            return strings.ToLower(s)
+        }
+    }
+    return s
+}
+
+// Formats language as language tag: subtags are '-' separated,
+// language is lower case, region upper case, script title case
+// (ie, EN_us is en-US, zh_hant_tw is zh-Hant-TW).
+func normalLanguage(s string) string {
+    if isNormalLanguage(s) {
+        return s
+    }
+    tags := strings.FieldsFunc(s, func(r rune) bool {
+        return r == '-' || r == '_'
+    })
+    for i, tag := range tags {
+        switch subtagCase(i, tag) {
+        case 'u':
+            tags[i] = strings.ToUpper(tag)
+        case 't':
+            tags[i] = strings.ToUpper(tag[:1]) + strings.ToLower(tag[1:])
+        default:
+            tags[i] = strings.ToLower(tag)
+        }
+    }
+    return strings.Join(tags, "-")
+}
+
+// Checks if language is already formatted, avoids allocations on lookups.
+func isNormalLanguage(s string) bool {
+    for i, start, n := 0, 0, 0; i <= len(s); i++ {
+        if i < len(s) && s[i] != '-' {
+            if s[i] == '_' {
+                return false
+            }
+            continue
+        }
+        tag := s[start:i]
+        if tag == "" && len(s) > 0 {
+            return false
+        }
+        switch subtagCase(n, tag) {
+        case 'u':
+            if hasLower(tag) {
+                return false
+            }
+        case 't':
+            if hasLower(tag[:1]) || hasUpper(tag[1:]) {
+                return false
+            }
+        default:
+            if hasUpper(tag) {
+                return false
+            }
+        }
+        start = i + 1
+        n++
+    }
+    return true
+}
+
+// Returns expected case of i-th subtag: 'u' upper for region,
+// 't' title for script, 'l' lower otherwise.
+func subtagCase(i int, tag string) byte {
+    if i == 0 {
+        return 'l'
+    }
+    if len(tag) == 2 {
+        return 'u'
+    }
+    if len(tag) == 4 && !hasDigit(tag) {
+        return 't'
+    }
+    return 'l'
+}
+
+func hasUpper(s string) bool {
+    for i := 0; i < len(s); i++ {
+        if 'A' <= s[i] && s[i] <= 'Z' {
+            return true
+        }
+    }
+    return false
+}
+
+func hasLower(s string) bool {
+    for i := 0; i < len(s); i++ {
+        if 'a' <= s[i] && s[i] <= 'z' {
+            return true
+        }
+    }
+    return false
+}
+
+func hasDigit(s string) bool {
+    for i := 0; i < len(s); i++ {
+        if '0' <= s[i] && s[i] <= '9' {
+            return true
+        }
+    }
+    return false
+}
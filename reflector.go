@@ -2,15 +2,19 @@
 package wiro
 
 import (
-    "fmt"
+    "log/slog"
     "reflect"
+    "strings"
 )
 
 //------------------------------------------------------------
 // 
 //------------------------------------------------------------
 
-func overlay(child, parent *Resource) {
+// Overlays empty child fields with parent values.
+// Returns paths of inherited fields (ie, Name, Extra.Info).
+// Warnings are logged to repository logger with child key.
+func overlay(log *slog.Logger, child, parent *Resource) (inherited []string) {
     if reflect.TypeOf(child) != reflect.TypeOf(parent) {
         panic("[wiro] types don't match")
     }
@@ -34,11 +38,13 @@ func overlay(child, parent *Resource) {
         panic("[wiro] parent Get() must return struct")
     }
 
-    keyType := reflect.TypeOf(*(*child).GetKey())
-    overlayValues(&childVal, &parentVal, keyType)
+    key := (*child).GetKey()
+    log = log.With("keydir", key.DirName(), "file", key.Id)
+    overlayValues(log, &childVal, &parentVal, reflect.TypeOf(*key), "", &inherited)
+    return
 }
 
-func overlayValues(child, parent *reflect.Value, skip reflect.Type) {
+func overlayValues(log *slog.Logger, child, parent *reflect.Value, skip reflect.Type, prefix string, inherited *[]string) {
     for i := 0; i < child.NumField(); i++ {
         f := child.Field(i)
 
@@ -46,52 +52,112 @@ func overlayValues(child, parent *reflect.Value, skip reflect.Type) {
             continue
         }
 
+        name := prefix + child.Type().Field(i).Name
         fp := parent.Field(i)
+        set := false
         switch f.Kind() {
         case reflect.String:
-            overlayString(&f, &fp)
+            set = overlayString(log, name, &f, &fp)
 
         case reflect.Slice:
-            overlaySlice(&f, &fp)
+            set = overlaySlice(log, name, &f, &fp)
 
         case reflect.Map:
-            overlayMap(&f, &fp)
+            set = overlayMap(log, name, &f, &fp)
 
         case reflect.Struct:
-            overlayValues(&f, &fp, skip)
+            overlayValues(log, &f, &fp, skip, name + ".", inherited)
 
         default:
-            fmt.Println("[wiro] skipping not supported field type:", f.Type())
+            log.Warn("Skipping not supported field type", "field", name, "type", f.Type().String())
+        }
+
+        if set {
+            *inherited = append(*inherited, name)
         }
     }
 }
 
-func overlayString(dst, src *reflect.Value) {
+func overlayString(log *slog.Logger, name string, dst, src *reflect.Value) bool {
     if dst.Len() == 0 && src.Len() > 0 {
         if dst.CanSet() {
             dst.Set(*src)
+            return true
         } else {
-            fmt.Println("[wiro] skipping setting string field:", dst.String())
+            log.Warn("Skipping setting string field", "field", name, "value", dst.String())
         }
     }
+    return false
 }
 
-func overlaySlice(dst, src *reflect.Value) {
+func overlaySlice(log *slog.Logger, name string, dst, src *reflect.Value) bool {
     if dst.IsNil() && !src.IsNil() {
         if dst.CanSet() {
             dst.Set(*src)
+            return true
         } else {
-            fmt.Println("[wiro] skipping setting slice field:", dst.String())
+            log.Warn("Skipping setting slice field", "field", name, "type", dst.Type().String())
         }
     }
+    return false
 }
 
-func overlayMap(dst, src *reflect.Value) {
+func overlayMap(log *slog.Logger, name string, dst, src *reflect.Value) bool {
     if dst.IsNil() && !src.IsNil() {
         if dst.CanSet() {
             dst.Set(*src)
+            return true
         } else {
-            fmt.Println("[wiro] skipping setting map field:", dst.String())
+            log.Warn("Skipping setting map field", "field", name, "type", dst.Type().String())
+        }
+    }
+    return false
+}
+
+//------------------------------------------------------------
+// Field inspection
+//------------------------------------------------------------
+
+// Returns leaf values of resource object by field path.
+// Structs are walked into, maps with string keys are walked
+// into by entry, Key is skipped.
+func fieldValues(obj interface{}) (fields map[string]reflect.Value) {
+    fields = map[string]reflect.Value{}
+    v := reflect.Indirect(reflect.ValueOf(obj))
+    if v.Kind() != reflect.Struct {
+        return
+    }
+    collectValues(v, "", reflect.TypeOf(Key{}), fields)
+    return
+}
+
+func collectValues(v reflect.Value, prefix string, skip reflect.Type, fields map[string]reflect.Value) {
+    switch {
+    case v.Kind() == reflect.Struct:
+        for i := 0; i < v.NumField(); i++ {
+            f := v.Type().Field(i)
+            if f.Type == skip || !f.IsExported() {
+                continue
+            }
+            collectValues(v.Field(i), prefix + f.Name + ".", skip, fields)
+        }
+
+    case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String && !v.IsNil():
+        iter := v.MapRange()
+        for iter.Next() {
+            collectValues(iter.Value(), prefix + iter.Key().String() + ".", skip, fields)
         }
+
+    default:
+        fields[strings.TrimSuffix(prefix, ".")] = v
+    }
+}
+
+// Checks if value is empty the way overlay sees it.
+func isEmptyValue(v reflect.Value) bool {
+    switch v.Kind() {
+    case reflect.String, reflect.Slice, reflect.Map:
+        return v.Len() == 0
     }
+    return v.IsZero()
 }
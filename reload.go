@@ -0,0 +1,280 @@
+// Reload events
+package wiro
+
+import (
+    "sync"
+    "time"
+    "github.com/deze333/wiro/fwatch"
+)
+
+// Size of subscription channel buffer
+const subscriptionBuffer = 16
+
+//------------------------------------------------------------
+// Reload event
+//------------------------------------------------------------
+
+// Describes completed repository reload.
+// Added, Changed and Removed list resource ids
+// with keys of affected variants.
+// Rejected is set when new content was not activated
+// and previous content stays live. Generation is the activated
+// snapshot generation, Rollback is set when it was reactivated
+// from history.
+type ReloadEvent struct {
+    RepoId   string
+    Generation int
+    Rollback bool
+    Paths    []string
+    Added    []ResourceChange
+    Changed  []ResourceChange
+    Removed  []ResourceChange
+    Errors   []error
+    Rejected bool
+    Time     time.Time
+    Duration time.Duration
+}
+
+// Resource id with keys of its affected variants.
+type ResourceChange struct {
+    Id   string
+    Keys []Key
+}
+
+// Variant identity within resource id
+type variant struct {
+    domain, language, version string
+}
+
+func variantOf(k *Key) variant {
+    return variant{k.Domain, k.Language, k.Version}
+}
+
+//------------------------------------------------------------
+// Reload event methods
+//------------------------------------------------------------
+
+// Compares old and new resources, filling in added, changed
+// and removed variants. Variant is changed when its file or
+// modification time differ; overlay propagates default
+// modification time to all variants.
+func (ev *ReloadEvent) diff(old, cur map[string][]*Resource) {
+    for id, rsrcs := range cur {
+        olds := map[variant]*Key{}
+        for _, rsrc := range old[id] {
+            k := (*rsrc).GetKey()
+            olds[variantOf(k)] = k
+        }
+
+        var added, changed []Key
+        for _, rsrc := range rsrcs {
+            k := (*rsrc).GetKey()
+            ok, found := olds[variantOf(k)]
+            switch {
+            case !found:
+                added = append(added, *k)
+            case ok.Path != k.Path || !ok.ModTime.Equal(k.ModTime):
+                changed = append(changed, *k)
+            }
+            delete(olds, variantOf(k))
+        }
+
+        var removed []Key
+        for _, k := range olds {
+            removed = append(removed, *k)
+        }
+
+        ev.Added = appendChange(ev.Added, id, added)
+        ev.Changed = appendChange(ev.Changed, id, changed)
+        ev.Removed = appendChange(ev.Removed, id, removed)
+    }
+
+    for id, rsrcs := range old {
+        if _, ok := cur[id]; ok {
+            continue
+        }
+        var removed []Key
+        for _, rsrc := range rsrcs {
+            removed = append(removed, *(*rsrc).GetKey())
+        }
+        ev.Removed = appendChange(ev.Removed, id, removed)
+    }
+}
+
+// Returns true if reload changed nothing.
+func (ev *ReloadEvent) IsEmpty() bool {
+    return len(ev.Added) == 0 && len(ev.Changed) == 0 && len(ev.Removed) == 0
+}
+
+func appendChange(changes []ResourceChange, id string, keys []Key) []ResourceChange {
+    if len(keys) == 0 {
+        return changes
+    }
+    return append(changes, ResourceChange{Id: id, Keys: keys})
+}
+
+//------------------------------------------------------------
+// Listeners
+//------------------------------------------------------------
+
+// Registers reload listener, returns function that removes it.
+func (r *Repo) addListener(fn func(ReloadEvent)) (remove func()) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    if r.listeners == nil {
+        r.listeners = map[int]func(ReloadEvent){}
+    }
+    r.listenerSeq++
+    id := r.listenerSeq
+    r.listeners[id] = fn
+
+    return func() {
+        r.mu.Lock()
+        delete(r.listeners, id)
+        r.mu.Unlock()
+    }
+}
+
+// Records last reload event and calls each reload listener in a goroutine.
+// Subscriptions never block and are sent to in order under lock.
+func (r *Repo) notify(ev ReloadEvent) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    r.lastEvent = &ev
+    for sub := range r.subs {
+        sub.send(ev)
+    }
+    if r.onReload != nil {
+        r.callback(r.onReload, ev)
+    }
+    for _, fn := range r.listeners {
+        r.callback(fn, ev)
+    }
+}
+
+// Runs listener in a goroutine tracked for repository close.
+// Called with r.mu held.
+func (r *Repo) callback(fn func(ReloadEvent), ev ReloadEvent) {
+    r.running++
+    go func() {
+        defer func() {
+            r.mu.Lock()
+            r.running--
+            if r.idle != nil {
+                r.idle.Broadcast()
+            }
+            r.mu.Unlock()
+        }()
+        fn(ev)
+    }()
+}
+
+// Waits for running listeners to finish.
+func (r *Repo) waitListeners() {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    if r.idle == nil {
+        r.idle = sync.NewCond(&r.mu)
+    }
+    for r.running > 0 {
+        r.idle.Wait()
+    }
+}
+
+// Registers subscription to be closed with repository.
+// Returns false if repository is already closed.
+func (r *Repo) addSubscription(sub *subscription) bool {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    if r.closed {
+        return false
+    }
+    if r.subs == nil {
+        r.subs = map[*subscription]bool{}
+    }
+    r.subs[sub] = true
+    return true
+}
+
+func (r *Repo) removeSubscription(sub *subscription) {
+    r.mu.Lock()
+    delete(r.subs, sub)
+    r.mu.Unlock()
+}
+
+// Stops repository watches and waits for in-flight reload.
+// If wait is set, also waits for reload listeners to finish.
+// Closes subscriptions and releases bundles.
+func (r *Repo) close(wait bool) {
+    // Wait for in-flight reload, prevent further ones
+    r.reloadMu.Lock()
+    r.mu.Lock()
+    r.closed = true
+    ids := r.WatchIds
+    r.WatchIds = []int{}
+    r.mu.Unlock()
+    fwatch.CloseMany(ids)
+    r.reloadMu.Unlock()
+
+    if wait {
+        r.waitListeners()
+    }
+
+    r.mu.Lock()
+    subs := r.subs
+    r.subs = nil
+    r.listeners = nil
+    r.onReload = nil
+    r.mu.Unlock()
+    for sub := range subs {
+        sub.close()
+    }
+
+    // Close active bundle and those of retained snapshots
+    r.reloadMu.Lock()
+    dropped := append(r.history, &Snapshot{bundle: r.bundle})
+    r.history, r.bundle = nil, nil
+    r.releaseBundles(dropped)
+    r.reloadMu.Unlock()
+}
+
+//------------------------------------------------------------
+// Channel subscription
+//------------------------------------------------------------
+
+// Reload events channel that can be safely closed
+// while events are being sent.
+type subscription struct {
+    ch     chan ReloadEvent
+    mu     sync.Mutex
+    closed bool
+}
+
+// Sends event without blocking, drops it if buffer is full.
+func (s *subscription) send(ev ReloadEvent) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if s.closed {
+        return
+    }
+    select {
+    case s.ch <- ev:
+    default:
+    }
+}
+
+// Closes channel, safe to call more than once.
+func (s *subscription) close() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if !s.closed {
+        s.closed = true
+        close(s.ch)
+    }
+}
@@ -0,0 +1,214 @@
+// Repository snapshot serialization
+package wiro
+
+import (
+    "encoding/gob"
+    "fmt"
+    "io/fs"
+    "os"
+    "path"
+    "path/filepath"
+    "time"
+)
+
+// Snapshot file format version
+const snapshotVersion = 4
+
+// Serialized repository.
+// Files maps key directories and parsed files to modification times,
+// used to detect stale snapshot. Key directories have zero time.
+// Inherited lists inherited fields of each resource.
+// NoNormalize and Aliases are normalization settings keys
+// were loaded with, snapshot is stale if they differ.
+type snapshotFile struct {
+    Version     int
+    NoNormalize bool
+    Aliases     string
+    Files       map[string]time.Time
+    Resources   []Resource
+    Inherited   [][]string
+}
+
+//------------------------------------------------------------
+// Exported functions
+//------------------------------------------------------------
+
+// Registers resource type for snapshot serialization,
+// pass pointer to resource struct (ie, &InfoText{}).
+// Resource types must be registered before snapshot is saved or loaded.
+func RegisterType(v Resource) {
+    gob.Register(v)
+}
+
+// Saves active repository resources into snapshot file.
+func SaveSnapshot(repoId, file string) (err error) {
+    repo, ok := getRepo(repoId)
+    if !ok {
+        return fmt.Errorf("Repository not found: %s", repoId)
+    }
+
+    repo.reloadMu.Lock()
+    defer repo.reloadMu.Unlock()
+    return repo.saveSnapshot(file)
+}
+
+//------------------------------------------------------------
+// Not exported functions
+//------------------------------------------------------------
+
+// Writes snapshot file atomically via temporary file.
+func (repo *Repo) saveSnapshot(file string) (err error) {
+    snap := snapshotFile{
+        Version: snapshotVersion,
+        NoNormalize: repo.opts.NoNormalize,
+        Aliases: repo.aliasFile(),
+        Files: repo.files,
+    }
+    cur := repo.Snapshot()
+    for _, rsrcs := range cur.Resources {
+        for _, rsrc := range rsrcs {
+            snap.Resources = append(snap.Resources, *rsrc)
+            snap.Inherited = append(snap.Inherited, cur.inherited[rsrc])
+        }
+    }
+
+    var f *os.File
+    f, err = os.CreateTemp(filepath.Dir(file), filepath.Base(file) + ".*")
+    if err != nil {
+        return
+    }
+    defer os.Remove(f.Name())
+
+    err = gob.NewEncoder(f).Encode(&snap)
+    if cerr := f.Close(); err == nil {
+        err = cerr
+    }
+    if err != nil {
+        return
+    }
+    return os.Rename(f.Name(), file)
+}
+
+// Loads repository from snapshot file if it is not stale.
+// Adds watches same as loadRoot does.
+func loadSnapshot(repo *Repo, file string) (err error) {
+    var f *os.File
+    f, err = os.Open(file)
+    if err != nil {
+        return
+    }
+    defer f.Close()
+
+    var snap snapshotFile
+    err = gob.NewDecoder(f).Decode(&snap)
+    if err != nil {
+        return
+    }
+    if snap.Version != snapshotVersion {
+        return fmt.Errorf("snapshot version %d, expected %d", snap.Version, snapshotVersion)
+    }
+    if snap.NoNormalize != repo.opts.NoNormalize || snap.Aliases != repo.aliasFile() {
+        return fmt.Errorf("snapshot normalization settings changed: %s", file)
+    }
+
+    var files map[string]time.Time
+    files, err = scanFiles(repo)
+    if err != nil {
+        return
+    }
+    if !sameFiles(files, snap.Files) {
+        return fmt.Errorf("snapshot is stale: %s", file)
+    }
+
+    // Aliases are unchanged, checked with files
+    var norm *normalizer
+    if !repo.opts.NoNormalize {
+        if norm, _, err = loadAliases(repo.fsys, repo.aliasFile()); err != nil {
+            return
+        }
+    }
+
+    // Snapshot holds overlaid resources, activate as is
+    resources := map[string][]*Resource{}
+    inherited := map[*Resource][]string{}
+    for i := range snap.Resources {
+        rsrc := snap.Resources[i]
+        if rsrc == nil {
+            continue
+        }
+        id := rsrc.GetKey().GetId()
+        resources[id] = append(resources[id], &rsrc)
+        if i < len(snap.Inherited) && len(snap.Inherited[i]) > 0 {
+            inherited[&rsrc] = snap.Inherited[i]
+        }
+    }
+    repo.norm = norm
+    repo.activate(resources, inherited)
+    repo.files = files
+
+    if !repo.opts.NoWatch {
+        watches := map[string]string{}
+        for name := range files {
+            if name == repo.aliasFile() {
+                continue
+            }
+            dir := path.Dir(name)
+            if dir == "." {
+                dir = name
+            }
+            watches[path.Join(repo.Dir, dir)] = dir
+        }
+        repo.watch(watches)
+    }
+    return
+}
+
+// Returns key directories and parsed files with modification times
+// without parsing them, same as loadRoot would record.
+func scanFiles(repo *Repo) (files map[string]time.Time, err error) {
+    var des []fs.DirEntry
+    des, err = fs.ReadDir(repo.fsys, ".")
+    if err != nil {
+        return
+    }
+
+    files = map[string]time.Time{}
+    if !repo.opts.NoNormalize {
+        if fi, err := fs.Stat(repo.fsys, repo.aliasFile()); err == nil {
+            files[repo.aliasFile()] = fi.ModTime()
+        }
+    }
+    for _, de := range des {
+        if !de.IsDir() {
+            continue
+        }
+        if _, _, _, err := parseDirName(de.Name()); err != nil {
+            continue
+        }
+        files[de.Name()] = time.Time{}
+
+        for f, parser := range parseFuncs(repo.Parsers, repo.Readers) {
+            if parser == nil {
+                continue
+            }
+            name := path.Join(de.Name(), f)
+            if fi, err := fs.Stat(repo.fsys, name); err == nil {
+                files[name] = fi.ModTime()
+            }
+        }
+    }
+    return
+}
+
+// Compares file sets and modification times.
+func sameFiles(a, b map[string]time.Time) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for name, t := range a {
+        if bt, ok := b[name]; !ok || !bt.Equal(t) {
+            return false
+        }
+    }
+    return true
+}
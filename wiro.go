@@ -2,8 +2,17 @@
 package wiro
 
 import (
+    "archive/zip"
+    "context"
 	"fmt"
+    "io"
+    "io/fs"
+    "log/slog"
+    "os"
+    "sync"
+    "sync/atomic"
     "time"
+    "github.com/deze333/wiro/fwatch"
 )
 
 //------------------------------------------------------------
@@ -13,11 +22,18 @@ import (
 // Global map of managed repositories
 var _library = map[string]*Repo{}
 
+// Guards library map
+var _libraryMu sync.RWMutex
+
 //------------------------------------------------------------
 // Repository
 //------------------------------------------------------------
 
 // Describes a repository located under Dir.
+// Resources is active content, use Snapshot for concurrent access.
+// Repository created from file system has empty Dir,
+// repository created from zip bundle has Zip set instead.
+// Parsers are given file path in key, Readers opened file.
 // Resources is a map, where key is the name of each resource,
 // (ie, home.ini, help/info.ini), they are provided by parse.
 // Each map entry contains a slice of resources
@@ -25,11 +41,68 @@ var _library = map[string]*Repo{}
 type Repo struct {
     Id        string
 	Dir       string
+    Zip       string
 	WatchIds  []int
     Parsers   *ParserLib
+    Readers   *ReaderParserLib
 	Resources map[string][]*Resource
 	resources map[string][]*Resource
-    onReload  func()
+    inherited map[*Resource][]string
+    fsys      fs.FS
+    bundle    *zip.ReadCloser
+    files     map[string]time.Time
+    loadFiles map[string]time.Time
+    norm      *normalizer
+    loadNorm  *normalizer
+    current   atomic.Pointer[Snapshot]
+    history   []*Snapshot
+    paused    bool
+    loadErrs  []error
+    reloadEv  *ReloadEvent
+    onReload  func(ReloadEvent)
+    onMiss    MissFunc
+    lastEvent *ReloadEvent
+    listeners map[int]func(ReloadEvent)
+    listenerSeq int
+    subs      map[*subscription]bool
+    running   int
+    idle      *sync.Cond
+    mu        sync.Mutex
+    reloadMu  sync.Mutex
+    closed    bool
+    opts      Options
+}
+
+//------------------------------------------------------------
+// Repository options
+//------------------------------------------------------------
+
+// Optional repository settings passed at Create.
+// Zero values mean defaults.
+type Options struct {
+    // Directory watch settings (debounce timings)
+    Watch fwatch.Options
+    // Disables directory watching, repository is then
+    // only reloaded by explicit Reload call.
+    NoWatch bool
+    // Snapshot file for fast start. Repository is loaded from it
+    // unless content changed since, otherwise repository is parsed
+    // and snapshot rewritten. Not used for zip bundles.
+    Snapshot string
+    // Number of activated snapshots retained for Rollback,
+    // including active one. Negative means only active one.
+    History int
+    // Validates candidate snapshot after overlay and resource
+    // validation. Error rejects snapshot, previous stays active.
+    Validate func(*Snapshot) error
+    // Logger for repository and its watches.
+    // Nil means package logger, see SetLogger.
+    Logger *slog.Logger
+    // Disables key normalization of directory names and lookups:
+    // case folding, language tag formatting, www. trimming and aliases.
+    NoNormalize bool
+    // Alias table file in repository root, default DefaultAliasFile.
+    Aliases string
 }
 
 //------------------------------------------------------------
@@ -43,17 +116,27 @@ type Resource interface {
 	Get() interface{}
 }
 
+// Optional interface, resources implementing it are validated
+// after overlay. Any error rejects loaded content.
+type Validator interface {
+    Validate() error
+}
+
 //------------------------------------------------------------
 // Key
 //------------------------------------------------------------
 
 // Key is unique identifier for each loaded resource.
-// Id can be file name. File is full path to that file.
+// Id can be file name. File is full path to that file for
+// repositories created from directory, empty for repositories
+// created from file system or zip bundle. Path is path of file
+// inside repository source (ie, com _ _/info.html).
 // Domain, Language are strings, empty "" means default.
 // Weight ranges from 0 to 100 (measured in %).
 type Key struct {
 	Id       string
 	File     string
+    Path     string
     ModTime  time.Time
 	Domain   string
 	Language string
@@ -64,6 +147,10 @@ type Key struct {
 type Parser func(Key)(interface{}, error)
 // Parser library maps file name to a parser.
 type ParserLib map[string]Parser
+// Reader parser converts opened file into resource according to key.
+type ReaderParser func(Key, io.Reader)(interface{}, error)
+// Reader parser library maps file name to a reader parser.
+type ReaderParserLib map[string]ReaderParser
 
 
 //------------------------------------------------------------
@@ -72,39 +159,131 @@ type ParserLib map[string]Parser
 
 // Creates resource from specified directory. All resources are of same homogenous type
 // which means same single parser used for all.
-func CreateHomogenous(id string, dir string, files []string, parser Parser) (err error) {
+func CreateHomogenous(id string, dir string, files []string, parser Parser, opts ...Options) (err error) {
     parsers := &ParserLib{}
     for _, f := range files {
         (*parsers)[f] = parser
     }
 
-    return Create(id, dir, parsers)
+    return Create(id, dir, parsers, opts...)
 }
 
 // Creates resource from specified directory and set of parsers.
-func Create(id string, dir string, parsers *ParserLib) (err error) {
-    repo := &Repo{
+// Optional opts customize repository behaviour.
+func Create(id string, dir string, parsers *ParserLib, opts ...Options) (err error) {
+    return install(newRepo(id, dir, os.DirFS(dir), parsers, nil, opts...))
+}
+
+// Creates resource from specified directory and set of reader parsers
+// that are given opened file instead of its path.
+func CreateReaders(id string, dir string, readers *ReaderParserLib, opts ...Options) (err error) {
+    return install(newRepo(id, dir, os.DirFS(dir), nil, readers, opts...))
+}
+
+// Creates resource from file system (ie, embed.FS, fstest.MapFS, zip.Reader)
+// and set of reader parsers. Such repository is not watched for changes,
+// use Reload to pick up changes if file system supports them.
+func CreateFS(id string, fsys fs.FS, readers *ReaderParserLib, opts ...Options) (err error) {
+    var o Options
+    if len(opts) > 0 {
+        o = opts[0]
+    }
+    o.NoWatch = true
+    return install(newRepo(id, "", fsys, nil, readers, o))
+}
+
+// Creates resource from zip bundle and set of reader parsers.
+// Bundle contains key directories at its root. Bundle is watched
+// for replacement (ie, rename over), new bundle is fully loaded
+// and activated in one step only if it loads without errors.
+func CreateZip(id string, file string, readers *ReaderParserLib, opts ...Options) (err error) {
+    repo := newRepo(id, "", nil, nil, readers, opts...)
+    repo.Zip = file
+    return install(repo)
+}
+
+func newRepo(id string, dir string, fsys fs.FS, parsers *ParserLib, readers *ReaderParserLib, opts ...Options) (repo *Repo) {
+    repo = &Repo{
         Id: id,
         Dir: dir, 
         WatchIds: []int{},
         Parsers: parsers,
+        Readers: readers,
         Resources: map[string][]*Resource{},
         resources: map[string][]*Resource{},
+        fsys: fsys,
     }
+    if len(opts) > 0 {
+        repo.opts = opts[0]
+    }
+    return
+}
+
+// Loads repository and installs it into library.
+func install(repo *Repo) (err error) {
     err = load(repo)
     if err != nil {
+        fwatch.CloseMany(repo.WatchIds)
         return
     }
     // Install loaded repository into library
-    _library[id] = repo
+    _libraryMu.Lock()
+    _library[repo.Id] = repo
+    _libraryMu.Unlock()
+    return
+}
+
+// Closes repository: removes it from library, stops its watches,
+// waits for in-flight reload and closes its subscription channels.
+// Close doesn't wait for running reload listeners so it never blocks
+// when called from a listener, use Shutdown to let them finish.
+func Close(repoId string) (err error) {
+    _libraryMu.Lock()
+    repo, ok := _library[repoId]
+    delete(_library, repoId)
+    _libraryMu.Unlock()
+
+    if !ok {
+        return fmt.Errorf("Repository not found: %s", repoId)
+    }
+    repo.close(false)
     return
 }
 
-// Sets optional reload listener.
-func SetOnReload(repoId string, fn func()) (err error) {
-    if repo, ok := _library[repoId]; ok {
+// Closes all repositories, waiting for running reload listeners
+// to finish. Returns context error if context is done before all
+// repositories finished closing. Must not be called from reload
+// listener, which would wait for itself until context is done.
+func Shutdown(ctx context.Context) (err error) {
+    _libraryMu.Lock()
+    repos := _library
+    _library = map[string]*Repo{}
+    _libraryMu.Unlock()
+
+    done := make(chan bool)
+    go func() {
+        for _, repo := range repos {
+            repo.close(true)
+        }
+        close(done)
+    }()
+
+    select {
+    case <-done:
+        return
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// Sets optional reload listener, replacing previously set one.
+// Listener is called in a goroutine after each reload.
+// Use AddOnReload or Subscribe for multiple listeners.
+func SetOnReload(repoId string, fn func(ReloadEvent)) (err error) {
+    if repo, ok := getRepo(repoId); ok {
+        repo.mu.Lock()
         repo.onReload = fn
-        _library[repoId] = repo
+        repo.mu.Unlock()
         return
 
     } else {
@@ -112,15 +291,76 @@ func SetOnReload(repoId string, fn func()) (err error) {
     }
 }
 
+// Adds reload listener in addition to existing ones.
+// Listener is called in a goroutine after each reload.
+// Returned remove function unregisters the listener.
+func AddOnReload(repoId string, fn func(ReloadEvent)) (remove func(), err error) {
+    repo, ok := getRepo(repoId)
+    if !ok {
+        return func() {}, fmt.Errorf("Repository not found: %s", repoId)
+    }
+    return repo.addListener(fn), nil
+}
+
+// Subscribes to reload events of repository.
+// Events are delivered on buffered channel, events that don't fit
+// into the buffer are dropped. Cancel unsubscribes and closes channel.
+// Channel is closed straight away if repository is not found.
+func Subscribe(repoId string) (events <-chan ReloadEvent, cancel func()) {
+    sub := &subscription{ch: make(chan ReloadEvent, subscriptionBuffer)}
+    repo, ok := getRepo(repoId)
+    if !ok || !repo.addSubscription(sub) {
+        sub.close()
+        return sub.ch, func() {}
+    }
+
+    return sub.ch, func() {
+        repo.removeSubscription(sub)
+        sub.close()
+    }
+}
+
+// Reloads repository synchronously and hot swaps it in,
+// same as done on directory change. Reload listeners are notified.
+// Returned error is set if repository directory can't be read,
+// parse errors are listed in event. Activated content resumes
+// automatic reloading paused by Rollback.
+func Reload(repoId string) (ev ReloadEvent, err error) {
+    repo, ok := getRepo(repoId)
+    if !ok {
+        err = fmt.Errorf("Repository not found: %s", repoId)
+        return
+    }
+    return repo.reload(nil)
+}
+
 // Retrieves resource from specified repository.
 // dlv is domain, language, version which can be omitted meaning default.
+// Unresolved lookups return resource of miss hook, see SetOnMiss.
 func Get(repoId, rsrcId string, dlv ...string) (rsrc *Resource) {
-    r := _library[repoId]
-    if r == nil {
+    domain, language, version := splitDLV(dlv)
+    r, ok := getRepo(repoId)
+    if !ok {
+        // Likely misconfiguration, counted as miss
+        recordLookup(repoId, rsrcId, 0)
+        return miss(nil, repoId, rsrcId, domain, language, version)
+    }
+    return r.Get(rsrcId, domain, language, version)
+}
+
+// Pins active snapshot of repository for consistent lookups,
+// ie, during single request rendering. Returns nil if repository
+// is not found, nil snapshot returns no resources.
+func Pin(repoId string) (snap *Snapshot) {
+    r, ok := getRepo(repoId)
+    if !ok {
         return nil
     }
+    return r.Snapshot()
+}
 
-    var domain, language, version string
+// Splits domain, language, version arguments.
+func splitDLV(dlv []string) (domain, language, version string) {
     switch len(dlv) {
     case 0:
     case 1:
@@ -137,14 +377,54 @@ func Get(repoId, rsrcId string, dlv ...string) (rsrc *Resource) {
         language = dlv[1]
         version = dlv[2]
     }
-
-    return r.Get(rsrcId, domain, language, version)
+    return
 }
 
 
+// Internal parser of file name inside fsys, as loader calls it.
+type parseFunc func(key Key, fsys fs.FS, name string) (interface{}, error)
+
+// Returns parsers by file name. Reader parsers are given file
+// opened from fsys, closed once parsed. Nil parser claims file
+// without parsing it.
+func parseFuncs(parsers *ParserLib, readers *ReaderParserLib) (fns map[string]parseFunc) {
+    fns = map[string]parseFunc{}
+    if parsers != nil {
+        for f, parser := range *parsers {
+            fns[f] = nil
+            if parser != nil {
+                parser := parser
+                fns[f] = func(key Key, fsys fs.FS, name string) (interface{}, error) {
+                    return parser(key)
+                }
+            }
+        }
+    }
+    if readers != nil {
+        for f, reader := range *readers {
+            fns[f] = nil
+            if reader != nil {
+                reader := reader
+                fns[f] = func(key Key, fsys fs.FS, name string) (obj interface{}, err error) {
+                    var file fs.File
+                    file, err = fsys.Open(name)
+                    if err != nil {
+                        return
+                    }
+                    defer file.Close()
+                    return reader(key, file)
+                }
+            }
+        }
+    }
+    return
+}
+
 // Retrieves repository
 func getRepo(id string) (repo *Repo, ok bool) {
+    _libraryMu.RLock()
     repo, ok = _library[id]
+    _libraryMu.RUnlock()
     return
 }
 
@@ -160,6 +440,26 @@ func (k *Key) GetKey() *Key {
 	return k
 }
 
+// Returns full path of key file, or its path inside
+// repository source if repository has no directory.
+func (k *Key) fileName() string {
+    if k.File == "" {
+        return k.Path
+    }
+    return k.File
+}
+
+// Returns key directory name (ie, com es _).
+func (k *Key) DirName() string {
+    name := func(s string) string {
+        if s == "" {
+            return "_"
+        }
+        return s
+    }
+    return name(k.Domain) + " " + name(k.Language) + " " + name(k.Version)
+}
+
 func (k *Key) Dump() {
 	fmt.Println("KEY =", k.Id, ",", k.Domain, ",", k.Language, ",", k.Version)
 }
@@ -171,41 +471,41 @@ func (k *Key) Dump() {
 // Adds resource to temporary repository.
 func (r *Repo) addTemp(k *Key, rsrc Resource) {
     if k == nil {
-        SOS("add", "Adding nil resource key")
+        r.log().Error("Adding nil resource key")
         return
     }
 
     if rsrc == nil {
-        SOS("add", "Adding nil resource")
+        r.log().Error("Adding nil resource", "keydir", k.DirName(), "file", k.Id)
         return
     }
-    // Ensure no duplicate resources
-    for _, rsrcs := range r.resources {
-        for _, r := range rsrcs {
-            if r == &rsrc {
-                SOS("addTemp", 
-                "This resource already present, skipping duplicate", 
-                "rsrc", rsrc)
-                return
-            }
+    // Ensure no duplicate resources, only variants of same id can clash
+    id := k.GetId()
+    v := variantOf(k)
+    for _, other := range r.resources[id] {
+        if variantOf((*other).GetKey()) == v {
+            r.log().Error("This resource already present, skipping duplicate",
+                "keydir", k.DirName(), "file", k.Id)
+            return
         }
     }
     // Add resource
-    id := k.GetId()
     r.resources[id] = append(r.resources[id], &rsrc)
     return
 }
 
-// Activates repository via hot swap
+// Activates overlaid temporary repository via hot swap
 func (r * Repo) hotSwapAll() {
-    r.overlayTemp()
-    r.Resources = r.resources
+    r.activate(r.resources, r.inherited)
     r.resources = map[string][]*Resource{}
 }
 
 
 // Overlays repository resources (specific over default).
+// Records inherited fields of each overlaid resource.
 func (r * Repo) overlayTemp() {
+    r.inherited = map[*Resource][]string{}
+
     // For each resource
     var defrsrc *Resource
     for _, rsrcs := range r.resources {
@@ -233,7 +533,7 @@ func (r * Repo) overlayTemp() {
             (*rsrc).GetKey().Version == "" {
                 continue
             }
-            overlay(rsrc, defrsrc)
+            r.inherited[rsrc] = overlay(r.log(), rsrc, defrsrc)
             // Adjust modified time stamp to the latest of the two
             if (*rsrc).GetKey().ModTime.Before((*defrsrc).GetKey().ModTime) {
                 (*rsrc).GetKey().ModTime = (*defrsrc).GetKey().ModTime
@@ -271,73 +571,149 @@ func (r *Repo) dumpTemp() {
     fmt.Println("----------------------------------------------------------------")
 }
 
+// Returns active snapshot. All lookups on returned snapshot
+// see same content regardless of hot swaps.
+func (r *Repo) Snapshot() *Snapshot {
+    return r.current.Load()
+}
+
 func (r *Repo) Get(id string, domain, language, version string) (rsrc *Resource) {
-    rsrcs, ok := r.Resources[id]
-    if !ok {
-        return
+    var step int
+    snap := r.Snapshot()
+    domain, language, version = snap.normalize(domain, language, version)
+    rsrc, step = snap.resolve(id, domain, language, version, nil)
+    recordLookup(r.Id, id, step)
+    if rsrc == nil {
+        rsrc = miss(r, r.Id, id, domain, language, version)
     }
+    return
+}
 
-    // Strategy:
-    // 1. Exact match
-    //    Try: D L V
-    // 2. Ignore L
-    //    Try: D _ V
-    // 3. Ignore V
-    //    Try: D _ _
-    // 4. Only V
-    //    Try: _ _ V
-    // 5. Default: _ _ _
-
-    // 1. Exact match: Domain - Lang - Ver
-    for _, rsrc = range rsrcs {
-        k := (*rsrc).GetKey()
-        if k.Domain == domain && 
-            k.Language == language && 
-            k.Version == version {
-                return rsrc
+//------------------------------------------------------------
+// Snapshot methods
+//------------------------------------------------------------
+
+// Retrieves resource from snapshot.
+// dlv is domain, language, version which can be omitted meaning default.
+func (s *Snapshot) Get(id string, dlv ...string) (rsrc *Resource) {
+    domain, language, version := s.normalize(splitDLV(dlv))
+    var step int
+    rsrc, step = s.resolve(id, domain, language, version, nil)
+    if s != nil && s.repoId != "" {
+        recordLookup(s.repoId, id, step)
+        if rsrc == nil {
+            repo, _ := getRepo(s.repoId)
+            rsrc = miss(repo, s.repoId, id, domain, language, version)
         }
     }
+    return
+}
 
-    // 2. Ignore language: Domain - _ - Ver
-    for _, rsrc = range rsrcs {
-        k := (*rsrc).GetKey()
-        if k.Domain == domain && 
-            k.Language == "" && 
-            k.Version == version {
-                return rsrc
-        }
+// Opens file of resource key from source snapshot was loaded from,
+// rolled back snapshot of zip repository reads from its own bundle.
+func (s *Snapshot) Open(key *Key) (f fs.File, err error) {
+    if s == nil || s.fsys == nil {
+        return nil, fmt.Errorf("Snapshot has no source: %s", key.Path)
     }
+    return s.fsys.Open(key.Path)
+}
 
-    // 3. Ignore version: Domain - _ - _
-    for _, rsrc = range rsrcs {
-        k := (*rsrc).GetKey()
-        if k.Domain == domain &&
-            k.Language == "" && 
-            k.Version == "" {
-                return rsrc
-        }
+// Returns lookup key normalized as snapshot key directories.
+func (s *Snapshot) normalize(domain, language, version string) (string, string, string) {
+    if s == nil {
+        return domain, language, version
     }
+    return s.norm.normalize(domain, language, version)
+}
 
-    // 3. Only version: _ - _ - Ver
-    for _, rsrc = range rsrcs {
-        k := (*rsrc).GetKey()
-        if k.Domain == "" &&
-            k.Language == "" && 
-            k.Version == version {
-                return rsrc
-        }
+func (s *Snapshot) get(id string, domain, language, version string) (rsrc *Resource) {
+    rsrc, _ = s.resolve(id, domain, language, version, nil)
+    return
+}
+
+// Fallback steps of resource lookup, in order of preference.
+// Each step maps requested key to candidate key.
+//
+// Strategy:
+// 1. Exact match
+//    Try: D L V
+// 2. Ignore L
+//    Try: D _ V
+// 3. Ignore V
+//    Try: D _ _
+// 4. Only V
+//    Try: _ _ V
+// 5. Default: _ _ _
+var fallbackSteps = []struct {
+    name string
+    candidate func(domain, language, version string) variant
+}{
+    {"exact", func(d, l, v string) variant { return variant{d, l, v} }},
+    {"ignore language", func(d, l, v string) variant { return variant{d, "", v} }},
+    {"ignore version", func(d, l, v string) variant { return variant{d, "", ""} }},
+    {"version only", func(d, l, v string) variant { return variant{"", "", v} }},
+    {"default", func(d, l, v string) variant { return variant{} }},
+}
+
+// Resolves resource through fallback steps.
+// Returns resource and its step number (1 based), 0 if not found.
+// Tried steps are appended to trace unless it is nil.
+func (s *Snapshot) resolve(id string, domain, language, version string, trace *[]ExplainStep) (rsrc *Resource, step int) {
+    if s == nil {
+        return
+    }
+    variants, ok := s.indexed()[id]
+    if !ok {
+        return
     }
 
-    // 5. Default: _ - _ - _
-    for _, rsrc := range rsrcs {
-        k := (*rsrc).GetKey()
-        if k.Domain == "" && 
-            k.Language == "" && 
-            k.Version == "" {
-                return rsrc
+    // Exact match and memoized fallbacks need no steps
+    want := variant{domain, language, version}
+    if trace == nil {
+        if want != (variant{}) {
+            if rsrc = variants[want]; rsrc != nil {
+                return rsrc, 1
+            }
         }
+        return s.fallback(id, variants, want)
     }
+    return resolveSteps(variants, want, trace)
+}
 
-    return nil
+// Tries fallback steps on indexed variants of resource.
+// Tried steps are appended to trace unless it is nil.
+func resolveSteps(variants map[variant]*Resource, want variant, trace *[]ExplainStep) (rsrc *Resource, step int) {
+    // Steps repeating earlier candidate are skipped and default
+    // is only tried as last step, ie, request without version
+    // resolves to default by "default" step, not by "version only"
+    last := len(fallbackSteps) - 1
+    tried := make([]variant, 0, len(fallbackSteps))
+StepLoop:
+    for i, st := range fallbackSteps {
+        cand := st.candidate(want.domain, want.language, want.version)
+        if cand == (variant{}) && i < last {
+            continue
+        }
+        for _, v := range tried {
+            if v == cand {
+                continue StepLoop
+            }
+        }
+        tried = append(tried, cand)
+
+        rsrc = variants[cand]
+        if trace != nil {
+            *trace = append(*trace, ExplainStep{
+                Step: i + 1,
+                Rule: st.name,
+                Key: Key{Domain: cand.domain, Language: cand.language, Version: cand.version},
+                Found: rsrc != nil,
+            })
+        }
+        if rsrc != nil {
+            return rsrc, i + 1
+        }
+    }
+    return nil, 0
 }
 
@@ -2,12 +2,24 @@
 package wiro
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/deze333/skini"
     "io/ioutil"
 	"os"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
 	"time"
 )
 
@@ -306,3 +318,985 @@ func infoTextParser(key Key) (rsrc interface{}, err error) {
     */
 	return txt, err
 }
+
+//------------------------------------------------------------
+// Reload event
+//------------------------------------------------------------
+
+func TestReloadEventDiff(t *testing.T) {
+	now := time.Now()
+	res := func(id, domain string, mod time.Time) *Resource {
+		var r Resource = &InfoText{Key: Key{Id: id, File: id, ModTime: mod, Domain: domain}}
+		return &r
+	}
+
+	old := map[string][]*Resource{
+		"info.ini": {res("info.ini", "", now), res("info.ini", "com", now)},
+		"home.ini": {res("home.ini", "", now)},
+	}
+	cur := map[string][]*Resource{
+		"info.ini": {res("info.ini", "", now.Add(time.Second)), res("info.ini", "com.au", now)},
+		"help.ini": {res("help.ini", "", now)},
+	}
+
+	ev := ReloadEvent{}
+	ev.diff(old, cur)
+
+	if len(ev.Added) != 2 || len(ev.Changed) != 1 || len(ev.Removed) != 2 {
+		t.Errorf("Unexpected diff: added %v, changed %v, removed %v", ev.Added, ev.Changed, ev.Removed)
+	}
+	if ev.Changed[0].Id != "info.ini" || ev.Changed[0].Keys[0].Domain != "" {
+		t.Errorf("Expected default info.ini changed, got %v", ev.Changed)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	repo := &Repo{Id: "subscribe"}
+	_library[repo.Id] = repo
+	defer delete(_library, repo.Id)
+
+	events, cancel := Subscribe(repo.Id)
+	calls := make(chan ReloadEvent, 1)
+	remove, err := AddOnReload(repo.Id, func(ev ReloadEvent) { calls <- ev })
+	if err != nil {
+		t.Fatalf("Error adding listener: %s", err)
+	}
+
+	repo.notify(ReloadEvent{RepoId: repo.Id})
+	for _, ch := range []<-chan ReloadEvent{events, calls} {
+		select {
+		case ev := <-ch:
+			if ev.RepoId != repo.Id {
+				t.Errorf("Unexpected event: %v", ev)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Event not delivered")
+		}
+	}
+
+	// Subscription receives events in order
+	remove()
+	for gen := 1; gen <= 5; gen++ {
+		repo.notify(ReloadEvent{RepoId: repo.Id, Generation: gen})
+	}
+	for gen := 1; gen <= 5; gen++ {
+		if ev := <-events; ev.Generation != gen {
+			t.Errorf("Expected generation %d, got %d", gen, ev.Generation)
+		}
+	}
+
+	cancel()
+	if _, ok := <-events; ok {
+		t.Errorf("Expected closed channel after cancel")
+	}
+
+	events, _ = Subscribe("no-such-repo")
+	if _, ok := <-events; ok {
+		t.Errorf("Expected closed channel for unknown repository")
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "_ _ _/info.html", "default")
+
+	err := CreateHomogenous("reload", dir, tplFiles, tplParser, Options{NoWatch: true})
+	if err != nil {
+		t.Fatalf("Error creating repository: %s", err)
+	}
+	defer Close("reload")
+
+	if repo, _ := getRepo("reload"); len(repo.WatchIds) != 0 {
+		t.Errorf("Expected no watches, got %v", repo.WatchIds)
+	}
+
+	writeFile(t, dir, "com _ _/info.html", "com")
+	ev, err := Reload("reload")
+	if err != nil {
+		t.Fatalf("Error reloading: %s", err)
+	}
+	if len(ev.Added) != 1 || ev.Added[0].Keys[0].Domain != "com" {
+		t.Errorf("Expected com variant added, got %v", ev.Added)
+	}
+
+	tpl := (*Get("reload", "info.html", "com")).Get().(*PageTpl)
+	if tpl.Html != "com" {
+		t.Errorf("Expected reloaded template, got %q", tpl.Html)
+	}
+}
+
+func writeFile(t *testing.T, dir, name, content string) {
+	fpath := path.Join(dir, name)
+	if err := os.MkdirAll(path.Dir(fpath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fpath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "_ _ _/info.html", "default")
+
+	err := CreateHomogenous("close", dir, tplFiles, tplParser)
+	if err != nil {
+		t.Fatalf("Error creating repository: %s", err)
+	}
+	if repo, _ := getRepo("close"); len(repo.WatchIds) == 0 {
+		t.Errorf("Expected watches")
+	}
+
+	events, _ := Subscribe("close")
+	if err = Close("close"); err != nil {
+		t.Fatalf("Error closing repository: %s", err)
+	}
+	if _, ok := <-events; ok {
+		t.Errorf("Expected subscription closed with repository")
+	}
+	if Get("close", "info.html") != nil {
+		t.Errorf("Expected no resources after close")
+	}
+	if _, err = Reload("close"); err == nil {
+		t.Errorf("Expected reload error after close")
+	}
+
+	// Listener may close its own repository
+	err = CreateHomogenous("close", dir, tplFiles, tplParser, Options{NoWatch: true})
+	if err != nil {
+		t.Fatalf("Error creating repository: %s", err)
+	}
+	closed := make(chan error, 1)
+	SetOnReload("close", func(ev ReloadEvent) {
+		closed <- Close("close")
+	})
+	Reload("close")
+	select {
+	case err = <-closed:
+		if err != nil {
+			t.Errorf("Error closing repository from listener: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Listener closing its repository deadlocked")
+	}
+
+	// Shutdown waits for running listeners
+	err = CreateHomogenous("shutdown", dir, tplFiles, tplParser)
+	if err != nil {
+		t.Fatalf("Error creating repository: %s", err)
+	}
+	var finished atomic.Bool
+	SetOnReload("shutdown", func(ev ReloadEvent) {
+		time.Sleep(100 * time.Millisecond)
+		finished.Store(true)
+	})
+	Reload("shutdown")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = Shutdown(ctx); err != nil {
+		t.Errorf("Error shutting down: %s", err)
+	}
+	if !finished.Load() {
+		t.Errorf("Expected shutdown to wait for running listener")
+	}
+	if _, ok := getRepo("shutdown"); ok {
+		t.Errorf("Expected no repositories after shutdown")
+	}
+}
+
+func TestCreateFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"_ _ _/info.html":     {Data: []byte("default"), ModTime: time.Now()},
+		"com _ _/info.html":   {Data: []byte("com"), ModTime: time.Now()},
+		"not a key/info.html": {Data: []byte("skipped")},
+	}
+	err := CreateFS("fs", fsys, &ReaderParserLib{"info.html": tplReaderParser})
+	if err != nil {
+		t.Fatalf("Error creating repository: %s", err)
+	}
+	defer Close("fs")
+
+	for _, tt := range []struct{ domain, html string }{
+		{"", "default"},
+		{"com", "com"},
+		{"com.au", "default"},
+	} {
+		tpl := (*Get("fs", "info.html", tt.domain)).Get().(*PageTpl)
+		if tpl.Html != tt.html {
+			t.Errorf("Domain %q: expected %q, got %q", tt.domain, tt.html, tpl.Html)
+		}
+	}
+	// Keys stay comparable, file has no path outside file system
+	k := *(*Get("fs", "info.html", "com")).GetKey()
+	seen := map[Key]bool{k: true}
+	if !seen[*(*Get("fs", "info.html", "com")).GetKey()] || k.File != "" || k.Path != "com _ _/info.html" {
+		t.Errorf("Expected comparable key with path only, got %+v", k)
+	}
+}
+
+func TestCreateZip(t *testing.T) {
+	dir := t.TempDir()
+	file := path.Join(dir, "bundle.zip")
+	writeZip(t, file, map[string]string{"_ _ _/info.html": "v1"})
+
+	err := CreateZip("zip", file, &ReaderParserLib{"info.html": tplReaderParser}, Options{NoWatch: true})
+	if err != nil {
+		t.Fatalf("Error creating repository: %s", err)
+	}
+	defer Close("zip")
+
+	// Replace bundle
+	writeZip(t, file, map[string]string{"_ _ _/info.html": "v2", "com _ _/info.html": "v2 com"})
+	ev, err := Reload("zip")
+	if err != nil || ev.Rejected {
+		t.Fatalf("Error reloading bundle: %s", err)
+	}
+	if tpl := (*Get("zip", "info.html", "com")).Get().(*PageTpl); tpl.Html != "v2 com" {
+		t.Errorf("Expected new bundle content, got %q", tpl.Html)
+	}
+
+	// Broken bundle is rejected, previous stays live
+	writeFile(t, dir, "bundle.zip", "not a zip")
+	ev, err = Reload("zip")
+	if err == nil || !ev.Rejected {
+		t.Errorf("Expected broken bundle to be rejected")
+	}
+	if tpl := (*Get("zip", "info.html")).Get().(*PageTpl); tpl.Html != "v2" {
+		t.Errorf("Expected previous bundle content, got %q", tpl.Html)
+	}
+
+	// Rolled back snapshot reads from its own bundle
+	if _, err = Rollback("zip", 1); err != nil {
+		t.Fatalf("Error rolling back: %s", err)
+	}
+	f, err := Pin("zip").Open((*Get("zip", "info.html")).GetKey())
+	if err != nil {
+		t.Fatalf("Error opening rolled back resource: %s", err)
+	}
+	if bytes, _ := io.ReadAll(f); string(bytes) != "v1" {
+		t.Errorf("Expected first bundle content, got %q", bytes)
+	}
+	f.Close()
+}
+
+func tplReaderParser(key Key, r io.Reader) (interface{}, error) {
+	bytes, err := io.ReadAll(r)
+	return &PageTpl{Key: key, Html: string(bytes)}, err
+}
+
+// Writes zip to temporary file and renames it over target.
+func writeZip(t *testing.T, file string, files map[string]string) {
+	f, err := os.Create(file + ".tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if err = os.Rename(file+".tmp", file); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "content/_ _ _/info.html", "default")
+	writeFile(t, dir, "content/com _ _/info.html", "")
+
+	RegisterType(&PageTpl{})
+	parsed := 0
+	parsers := &ParserLib{"info.html": func(key Key) (interface{}, error) {
+		parsed++
+		return tplParser(key)
+	}}
+	opts := Options{NoWatch: true, Snapshot: path.Join(dir, "content.snapshot")}
+
+	// Parsed and snapshot written, then loaded from snapshot
+	for i, expected := range []int{2, 2} {
+		if err := Create("snapshot", path.Join(dir, "content"), parsers, opts); err != nil {
+			t.Fatalf("Error creating repository: %s", err)
+		}
+		if parsed != expected {
+			t.Errorf("Start %d: expected %d files parsed, got %d", i, expected, parsed)
+		}
+		tpl := (*Get("snapshot", "info.html", "com")).Get().(*PageTpl)
+		if tpl.Html != "default" {
+			t.Errorf("Start %d: expected overlaid content, got %q", i, tpl.Html)
+		}
+		Close("snapshot")
+	}
+
+	// Stale snapshot is not used
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path.Join(dir, "content/com _ _/info.html"), later, later)
+	if err := Create("snapshot", path.Join(dir, "content"), parsers, opts); err != nil {
+		t.Fatalf("Error creating repository: %s", err)
+	}
+	defer Close("snapshot")
+	if parsed != 4 {
+		t.Errorf("Expected stale snapshot to be reparsed, got %d files parsed", parsed)
+	}
+}
+
+func TestSnapshotNormalize(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "content/_ _ _/info.html", "default")
+	writeFile(t, dir, "content/COM _ _/info.html", "com")
+
+	RegisterType(&PageTpl{})
+	opts := Options{NoWatch: true, NoNormalize: true, Snapshot: path.Join(dir, "content.snapshot")}
+	if err := CreateHomogenous("snapnorm", path.Join(dir, "content"), tplFiles, tplParser, opts); err != nil {
+		t.Fatalf("Error creating repository: %s", err)
+	}
+	Close("snapnorm")
+
+	// Snapshot saved without normalization is not used
+	opts.NoNormalize = false
+	if err := CreateHomogenous("snapnorm", path.Join(dir, "content"), tplFiles, tplParser, opts); err != nil {
+		t.Fatalf("Error creating repository: %s", err)
+	}
+	defer Close("snapnorm")
+	for _, domain := range []string{"com", "COM"} {
+		if tpl := (*Get("snapnorm", "info.html", domain)).Get().(*PageTpl); tpl.Html != "com" {
+			t.Errorf("Domain %q: expected normalized com resource, got %q", domain, tpl.Html)
+		}
+	}
+}
+
+func TestRollback(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "_ _ _/info.html", "v1")
+
+	err := CreateHomogenous("rollback", dir, tplFiles, tplParser, Options{NoWatch: true, History: 2})
+	if err != nil {
+		t.Fatalf("Error creating repository: %s", err)
+	}
+	defer Close("rollback")
+
+	for _, html := range []string{"v2", "v3"} {
+		writeFile(t, dir, "_ _ _/info.html", html)
+		Reload("rollback")
+	}
+
+	snaps, _ := History("rollback")
+	if len(snaps) != 2 || snaps[0].Generation != 2 || snaps[1].Generation != 3 {
+		t.Fatalf("Expected generations 2 and 3 retained, got %d snapshots", len(snaps))
+	}
+	if _, err = Rollback("rollback", 1); err == nil {
+		t.Errorf("Expected error rolling back to dropped generation")
+	}
+
+	ev, err := Rollback("rollback", 2)
+	if err != nil {
+		t.Fatalf("Error rolling back: %s", err)
+	}
+	if !ev.Rollback || ev.Generation != 2 || ev.Duration <= 0 {
+		t.Errorf("Unexpected rollback event: %v", ev)
+	}
+	if tpl := (*Get("rollback", "info.html")).Get().(*PageTpl); tpl.Html != "v2" {
+		t.Errorf("Expected rolled back content, got %q", tpl.Html)
+	}
+	repo, _ := getRepo("rollback")
+	if !repo.isPaused() {
+		t.Errorf("Expected automatic reloading paused after rollback")
+	}
+
+	// Explicit reload activates latest content and resumes
+	if ev, err = Reload("rollback"); err != nil || ev.Generation != 4 {
+		t.Errorf("Expected reload to activate generation 4, got %d (%v)", ev.Generation, err)
+	}
+	if repo.isPaused() {
+		t.Errorf("Expected automatic reloading resumed after reload")
+	}
+	if _, err = Rollback("rollback", 3); err != nil {
+		t.Fatalf("Error rolling back: %s", err)
+	}
+
+	if ev, err = Resume("rollback"); err != nil || ev.Generation != 5 {
+		t.Errorf("Expected resume to activate generation 5, got %d (%v)", ev.Generation, err)
+	}
+	if tpl := (*Get("rollback", "info.html")).Get().(*PageTpl); tpl.Html != "v3" {
+		t.Errorf("Expected latest content after resume, got %q", tpl.Html)
+	}
+}
+
+func TestPin(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "_ _ _/info.html", "v1")
+
+	err := CreateHomogenous("pin", dir, tplFiles, tplParser, Options{NoWatch: true})
+	if err != nil {
+		t.Fatalf("Error creating repository: %s", err)
+	}
+	defer Close("pin")
+
+	snap := Pin("pin")
+	writeFile(t, dir, "_ _ _/info.html", "v2")
+	Reload("pin")
+
+	if tpl := (*snap.Get("info.html", "com")).Get().(*PageTpl); tpl.Html != "v1" {
+		t.Errorf("Expected pinned content, got %q", tpl.Html)
+	}
+	if tpl := (*Get("pin", "info.html", "com")).Get().(*PageTpl); tpl.Html != "v2" {
+		t.Errorf("Expected new content, got %q", tpl.Html)
+	}
+	if Pin("no-such-repo").Get("info.html") != nil {
+		t.Errorf("Expected no resource from unknown repository")
+	}
+
+	// Published snapshot never changes, event is set before
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_ = Pin("pin").Event.Generation
+		}
+	}()
+	ev, _ := Reload("pin")
+	<-done
+	if snap := Pin("pin"); snap.Event.Generation != snap.Generation || ev.Generation != snap.Generation {
+		t.Errorf("Expected event of generation %d, got %d", snap.Generation, snap.Event.Generation)
+	}
+}
+
+type ValidTpl struct {
+	PageTpl
+}
+
+func (t *ValidTpl) Validate() error {
+	if t.Html == "" {
+		return fmt.Errorf("empty template")
+	}
+	return nil
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "_ _ _/info.html", "v1")
+
+	parsers := &ParserLib{"info.html": func(key Key) (interface{}, error) {
+		tpl, err := tplParser(key)
+		if err != nil {
+			return nil, err
+		}
+		return &ValidTpl{*tpl.(*PageTpl)}, nil
+	}}
+	opts := Options{NoWatch: true, Validate: func(snap *Snapshot) error {
+		if snap.Get("info.html", "com") == nil {
+			return fmt.Errorf("missing info.html")
+		}
+		return nil
+	}}
+	err := Create("validate", dir, parsers, opts)
+	if err != nil {
+		t.Fatalf("Error creating repository: %s", err)
+	}
+	defer Close("validate")
+
+	// Resource validation rejects empty template
+	writeFile(t, dir, "_ _ _/info.html", "")
+	ev, err := Reload("validate")
+	if err == nil || !ev.Rejected || len(ev.Errors) != 1 {
+		t.Errorf("Expected rejected reload with 1 error, got %v, %v", err, ev.Errors)
+	}
+	if tpl := (*Get("validate", "info.html")).Get().(*PageTpl); tpl.Html != "v1" {
+		t.Errorf("Expected previous content, got %q", tpl.Html)
+	}
+
+	// Repository validation rejects missing resource
+	os.Remove(path.Join(dir, "_ _ _/info.html"))
+	if ev, _ = Reload("validate"); !ev.Rejected {
+		t.Errorf("Expected rejected reload")
+	}
+}
+
+//------------------------------------------------------------
+// Coverage
+//------------------------------------------------------------
+
+// Parses "Name|Phone" text into InfoText
+func infoReaderParser(key Key, r io.Reader) (interface{}, error) {
+	bytes, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	txt := &InfoText{Key: key}
+	parts := strings.SplitN(string(bytes), "|", 2)
+	txt.Name = parts[0]
+	if len(parts) > 1 {
+		txt.Phone = parts[1]
+	}
+	return txt, nil
+}
+
+func TestCoverage(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "_ _ _/info.txt", "Alex|555")
+	writeFile(t, dir, "com _ _/info.txt", "Alexander")
+	writeFile(t, dir, "com _ _/promo.txt", "Sale")
+
+	parsers := &ReaderParserLib{"info.txt": infoReaderParser, "promo.txt": infoReaderParser}
+	err := CreateReaders("coverage", dir, parsers, Options{NoWatch: true})
+	if err != nil {
+		t.Fatalf("Error creating repository: %s", err)
+	}
+	defer Close("coverage")
+
+	report, err := Coverage("coverage")
+	if err != nil {
+		t.Fatalf("Error reporting coverage: %s", err)
+	}
+	if len(report.Keys) != 2 || report.Keys[0].DirName() != "_ _ _" || report.Keys[1].DirName() != "com _ _" {
+		t.Fatalf("Expected keys [_ _ _] [com _ _], got %v", report.Keys)
+	}
+	if len(report.Resources) != 2 {
+		t.Fatalf("Expected 2 resources, got %d", len(report.Resources))
+	}
+
+	status := func(rc ResourceCoverage, k int, field string) CoverageStatus {
+		for _, fc := range rc.Variants[k].Fields {
+			if fc.Field == field {
+				return fc.Status
+			}
+		}
+		t.Fatalf("Field %s not found in %s", field, rc.Id)
+		return Missing
+	}
+
+	info, promo := report.Resources[0], report.Resources[1]
+	if info.Variants[1].Status != Present || status(info, 1, "Name") != Present {
+		t.Errorf("Expected com info.txt name present")
+	}
+	if status(info, 1, "Phone") != Inherited {
+		t.Errorf("Expected com info.txt phone inherited, got %s", status(info, 1, "Phone"))
+	}
+	if status(info, 1, "Extra.Mask") != Missing {
+		t.Errorf("Expected com info.txt extra mask missing")
+	}
+	if promo.Variants[0].Status != Missing || promo.Variants[1].Status != Present {
+		t.Errorf("Expected promo.txt missing by default, got %v", promo.Variants)
+	}
+
+	if _, err = Coverage("no-such-repo"); err == nil {
+		t.Errorf("Expected error for unknown repository")
+	}
+}
+
+//------------------------------------------------------------
+// Lint
+//------------------------------------------------------------
+
+func TestLint(t *testing.T) {
+	fsys := fstest.MapFS{
+		"_ _ _/info.html":      {Data: []byte("v1")},
+		"_ _ _/notes.txt":      {Data: []byte("notes")},
+		"com _ _/info.html":    {Data: []byte("v1")},
+		"COM _ _/info.html":    {Data: []byte("")},
+		"com/info.html":        {Data: []byte("v1")},
+		"dotcom _ _/info.html": {Data: []byte("v1")},
+		"keys.ini":             {Data: []byte("[domain]\ndotcom = com\n")},
+		"README":               {Data: []byte("")},
+		".git/config":          {Data: []byte("")},
+	}
+	parsers := &ReaderParserLib{"info.html": func(key Key, r io.Reader) (interface{}, error) {
+		bytes, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes) == 0 {
+			return nil, fmt.Errorf("empty template")
+		}
+		return &PageTpl{Key: key, Html: string(bytes)}, nil
+	}}
+
+	problems, err := LintFS(fsys, parsers, "keys.ini")
+	if err != nil {
+		t.Fatalf("Error linting: %s", err)
+	}
+	expected := []string{"COM _ _/info.html", "README", "_ _ _/notes.txt", "com", "com _ _", "dotcom _ _"}
+	if len(problems) != len(expected) {
+		t.Fatalf("Expected %d problems, got %v", len(expected), problems)
+	}
+	for i, p := range problems {
+		if p.Path != expected[i] {
+			t.Errorf("Expected problem in %s, got %s", expected[i], p)
+		}
+	}
+}
+
+//------------------------------------------------------------
+// Explain
+//------------------------------------------------------------
+
+func TestExplain(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "_ _ _/info.txt", "Alex|555")
+	writeFile(t, dir, "com _ _/info.txt", "Alexander")
+
+	err := CreateReaders("explain", dir, &ReaderParserLib{"info.txt": infoReaderParser}, Options{NoWatch: true})
+	if err != nil {
+		t.Fatalf("Error creating repository: %s", err)
+	}
+	defer Close("explain")
+
+	ex, err := Explain("explain", "info.txt", "com", "es", "blue")
+	if err != nil {
+		t.Fatalf("Error explaining: %s", err)
+	}
+	if len(ex.Steps) != 3 || ex.Steps[2].Rule != "ignore version" || !ex.Steps[2].Found {
+		t.Errorf("Expected match on 3rd step, got %v", ex.Steps)
+	}
+	if len(ex.Candidates) != 2 || ex.Dir != "com _ _" || ex.File != path.Join(dir, "com _ _/info.txt") {
+		t.Errorf("Expected com _ _ winner of 2 candidates, got %s %s %v", ex.Dir, ex.File, ex.Candidates)
+	}
+	if len(ex.Inherited) != 1 || ex.Inherited[0].Field != "Phone" || ex.Inherited[0].From.DirName() != "_ _ _" {
+		t.Errorf("Expected Phone inherited from _ _ _, got %v", ex.Inherited)
+	}
+
+	if ex = Pin("explain").Explain("no-such-resource"); ex.Resource != nil || len(ex.Steps) != 0 {
+		t.Errorf("Expected unresolved resource, got %v", ex)
+	}
+}
+
+//------------------------------------------------------------
+// Debug handler
+//------------------------------------------------------------
+
+func TestHandler(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "_ _ _/info.txt", "Alex|555")
+	writeFile(t, dir, "com _ _/info.txt", "Alexander")
+
+	err := CreateReaders("debug", dir, &ReaderParserLib{"info.txt": infoReaderParser}, Options{NoWatch: true})
+	if err != nil {
+		t.Fatalf("Error creating repository: %s", err)
+	}
+	defer Close("debug")
+	Reload("debug")
+
+	get := func(query string, v interface{}) int {
+		w := httptest.NewRecorder()
+		Handler().ServeHTTP(w, httptest.NewRequest("GET", "/debug/wiro?" + query, nil))
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+				t.Fatalf("Error decoding %s: %s", query, err)
+			}
+		}
+		return w.Code
+	}
+
+	var repo debugRepo
+	if code := get("repo=debug", &repo); code != http.StatusOK {
+		t.Fatalf("Expected status OK, got %d", code)
+	}
+	if len(repo.Resources["info.txt"]) != 2 || repo.LastReload == nil || repo.Generation != 2 {
+		t.Errorf("Expected 2 variants after reload, got %+v", repo)
+	}
+
+	var res struct {
+		Explanation Explanation
+		Object      InfoText
+	}
+	get("repo=debug&id=info.txt&d=com", &res)
+	if res.Explanation.Dir != "com _ _" || res.Object.Phone != "555" {
+		t.Errorf("Expected com _ _ with inherited phone, got %+v", res)
+	}
+
+	if code := get("repo=no-such-repo", &repo); code != http.StatusNotFound {
+		t.Errorf("Expected status not found, got %d", code)
+	}
+
+	// Debug view doesn't wait for reload in progress
+	r, _ := getRepo("debug")
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+	done := make(chan int, 1)
+	go func() {
+		w := httptest.NewRecorder()
+		Handler().ServeHTTP(w, httptest.NewRequest("GET", "/debug/wiro?repo=debug", nil))
+		done <- w.Code
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("Debug view blocked by reload in progress")
+	}
+}
+
+//------------------------------------------------------------
+// Metrics
+//------------------------------------------------------------
+
+type testMetrics struct {
+	sync.Mutex
+	steps   map[int]int
+	reloads int
+	parse   int
+}
+
+func (m *testMetrics) Lookup(repoId, id string, step int) {
+	m.Lock()
+	defer m.Unlock()
+	m.steps[step]++
+}
+
+func (m *testMetrics) Reload(repoId string, d time.Duration, rejected bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.reloads++
+}
+
+func (m *testMetrics) ParseError(repoId, file string) {
+	m.Lock()
+	defer m.Unlock()
+	m.parse++
+}
+
+func TestMetrics(t *testing.T) {
+	m := &testMetrics{steps: map[int]int{}}
+	SetMetrics(m)
+	defer SetMetrics(nil)
+
+	dir := t.TempDir()
+	writeFile(t, dir, "_ _ _/info.txt", "Alex|555")
+	writeFile(t, dir, "com _ _/info.txt", "Alexander")
+
+	parsers := &ReaderParserLib{"info.txt": infoReaderParser, "bad.txt": func(key Key, r io.Reader) (interface{}, error) {
+		return nil, fmt.Errorf("bad")
+	}}
+	err := CreateReaders("metrics", dir, parsers, Options{NoWatch: true})
+	if err != nil {
+		t.Fatalf("Error creating repository: %s", err)
+	}
+	defer Close("metrics")
+
+	Get("metrics", "info.txt", "com", "es")
+	Get("metrics", "info.txt", "org")
+	Pin("metrics").Get("info.txt", "com")
+	Get("metrics", "no-such-resource")
+	Get("no-such-repo", "info.txt")
+	writeFile(t, dir, "_ _ _/bad.txt", "")
+	Reload("metrics")
+
+	m.Lock()
+	defer m.Unlock()
+	if m.steps[2] != 1 || m.steps[5] != 1 || m.steps[1] != 1 || m.steps[0] != 2 {
+		t.Errorf("Expected one lookup per step 1, 2, 5 and two misses, got %v", m.steps)
+	}
+	if m.reloads != 1 || m.parse != 1 {
+		t.Errorf("Expected 1 reload with 1 parse error, got %d, %d", m.reloads, m.parse)
+	}
+}
+
+//------------------------------------------------------------
+// Logging
+//------------------------------------------------------------
+
+func TestLogger(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "_ _ _/info.txt", "Alex|555")
+	writeFile(t, dir, "_ _ _/bad.txt", "")
+
+	var buf bytes.Buffer
+	log := slog.New(slog.NewJSONHandler(&buf, nil))
+	parsers := &ReaderParserLib{"info.txt": infoReaderParser, "bad.txt": func(key Key, r io.Reader) (interface{}, error) {
+		return nil, fmt.Errorf("bad")
+	}}
+	err := CreateReaders("logger", dir, parsers, Options{NoWatch: true, Logger: log})
+	if err != nil {
+		t.Fatalf("Error creating repository: %s", err)
+	}
+	defer Close("logger")
+
+	var rec map[string]interface{}
+	if err = json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("Expected single JSON record, got %q", buf.String())
+	}
+	if rec["repo"] != "logger" || rec["keydir"] != "_ _ _" || rec["file"] != "bad.txt" || rec["err"] != "bad" {
+		t.Errorf("Expected repo, keydir, file and err attributes, got %v", rec)
+	}
+}
+
+type CountedTpl struct {
+	Key
+	Html  string
+	Views int
+}
+
+func (t *CountedTpl) Get() interface{} {
+	return t
+}
+
+func TestOverlayLogger(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "_ _ _/info.html", "default")
+	writeFile(t, dir, "com _ _/info.html", "")
+
+	var buf bytes.Buffer
+	log := slog.New(slog.NewJSONHandler(&buf, nil))
+	parser := func(key Key) (interface{}, error) {
+		page, err := tplParser(key)
+		if err != nil {
+			return nil, err
+		}
+		return &CountedTpl{Key: key, Html: page.(*PageTpl).Html}, nil
+	}
+	err := CreateHomogenous("overlaylog", dir, tplFiles, parser, Options{NoWatch: true, Logger: log})
+	if err != nil {
+		t.Fatalf("Error creating repository: %s", err)
+	}
+	defer Close("overlaylog")
+
+	var rec map[string]interface{}
+	if err = json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("Expected single JSON record, got %q", buf.String())
+	}
+	if rec["repo"] != "overlaylog" || rec["keydir"] != "com _ _" || rec["file"] != "info.html" || rec["field"] != "Views" {
+		t.Errorf("Expected repo, keydir, file and field attributes, got %v", rec)
+	}
+}
+
+//------------------------------------------------------------
+// Miss hook
+//------------------------------------------------------------
+
+func TestOnMiss(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "com _ _/info.html", "com")
+
+	err := CreateHomogenous("miss", dir, tplFiles, tplParser, Options{NoWatch: true})
+	if err != nil {
+		t.Fatalf("Error creating repository: %s", err)
+	}
+	defer Close("miss")
+
+	var placeholder Resource = &PageTpl{Html: "placeholder"}
+	missed := []string{}
+	SetDefaultOnMiss(func(repoId string, key Key) *Resource {
+		missed = append(missed, repoId + ":" + key.Id)
+		return nil
+	})
+	defer SetDefaultOnMiss(nil)
+
+	if Get("no-such-repo", "info.html") != nil || Get("miss", "info.html", "org") != nil {
+		t.Errorf("Expected no resource from library hook")
+	}
+	if len(missed) != 2 || missed[0] != "no-such-repo:info.html" {
+		t.Errorf("Expected 2 misses, got %v", missed)
+	}
+
+	SetOnMiss("miss", func(repoId string, key Key) *Resource {
+		if key.Domain == "org" {
+			return &placeholder
+		}
+		return nil
+	})
+	if rsrc := Pin("miss").Get("info.html", "org"); rsrc != &placeholder {
+		t.Errorf("Expected placeholder, got %v", rsrc)
+	}
+	if rsrc := Get("miss", "info.html", "com"); (*rsrc).Get().(*PageTpl).Html != "com" {
+		t.Errorf("Expected resolved resource, got %v", rsrc)
+	}
+	if len(missed) != 2 {
+		t.Errorf("Expected repository hook to replace library hook, got %v", missed)
+	}
+	if SetOnMiss("no-such-repo", nil) == nil {
+		t.Errorf("Expected error for unknown repository")
+	}
+}
+
+//------------------------------------------------------------
+// Index
+//------------------------------------------------------------
+
+func TestIndex(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "_ _ _/info.html", "default")
+	writeFile(t, dir, "com _ _/info.html", "com")
+
+	err := CreateHomogenous("index", dir, tplFiles, tplParser, Options{NoWatch: true})
+	if err != nil {
+		t.Fatalf("Error creating repository: %s", err)
+	}
+	defer Close("index")
+
+	snap := Pin("index")
+	for i := 0; i < 2; i++ {
+		if tpl := (*snap.Get("info.html", "com", "es")).Get().(*PageTpl); tpl.Html != "com" {
+			t.Errorf("Expected com fallback, got %q", tpl.Html)
+		}
+		if tpl := (*snap.Get("info.html", "com")).Get().(*PageTpl); tpl.Html != "com" {
+			t.Errorf("Expected com exact match, got %q", tpl.Html)
+		}
+		if snap.Get("no-such-resource", "com") != nil {
+			t.Errorf("Expected no resource")
+		}
+	}
+
+	// Only fallback is memoized, exact match and unknown id are not
+	if n := snap.nResolved.Load(); n != 1 {
+		t.Errorf("Expected 1 memoized fallback, got %d", n)
+	}
+	if rsrc, step := snap.resolve("info.html", "com", "es", "", nil); step != 2 || rsrc == nil {
+		t.Errorf("Expected memoized step 2, got %d", step)
+	}
+}
+
+//------------------------------------------------------------
+// Normalization
+//------------------------------------------------------------
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in, domain, language, version string
+	}{
+		{"COM en_us Big", "com", "en-US", "big"},
+		{"www.Example.com zh_hant_tw _", "example.com", "zh-Hant-TW", ""},
+		{"_ es-419 _", "", "es-419", ""},
+		{"co.uk iw _", "uk", "he", ""},
+	}
+
+	n := newNormalizer()
+	n.domains["co.uk"] = "uk"
+	n.languages["iw"] = "he"
+	for _, test := range tests {
+		d, l, v, _ := parseDirName(test.in)
+		d, l, v = n.normalize(d, l, v)
+		if d != test.domain || l != test.language || v != test.version {
+			t.Errorf("%s: expected %q %q %q, got %q %q %q", test.in, test.domain, test.language, test.version, d, l, v)
+		}
+	}
+
+	dir := t.TempDir()
+	writeFile(t, dir, "_ _ _/info.html", "default")
+	writeFile(t, dir, "UK en_GB _/info.html", "uk")
+	writeFile(t, dir, "aliases.ini", "[domain]\nco.uk = uk\n")
+
+	err := CreateHomogenous("normalize", dir, tplFiles, tplParser, Options{NoWatch: true})
+	if err != nil {
+		t.Fatalf("Error creating repository: %s", err)
+	}
+	defer Close("normalize")
+
+	if tpl := (*Get("normalize", "info.html", "www.CO.uk", "en-gb")).Get().(*PageTpl); tpl.Html != "uk" {
+		t.Errorf("Expected uk content, got %q", tpl.Html)
+	}
+
+	// Invalid alias table rejects reload
+	writeFile(t, dir, "aliases.ini", "co.uk = uk\n")
+	if ev, _ := Reload("normalize"); !ev.Rejected {
+		t.Errorf("Expected rejected reload")
+	}
+	if tpl := (*Get("normalize", "info.html", "co.uk", "en_GB")).Get().(*PageTpl); tpl.Html != "uk" {
+		t.Errorf("Expected previous aliases, got %q", tpl.Html)
+	}
+}
@@ -0,0 +1,74 @@
+// Zip bundle repository source
+package wiro
+
+import (
+    "archive/zip"
+    "fmt"
+    "path/filepath"
+    "strings"
+    "github.com/deze333/wiro/fwatch"
+)
+
+//------------------------------------------------------------
+// Bundle loading
+//------------------------------------------------------------
+
+// Opens repository zip bundle for loading.
+func openBundle(repo *Repo) (bundle *zip.ReadCloser, err error) {
+    bundle, err = zip.OpenReader(repo.Zip)
+    if err != nil {
+        repo.log().Error("Error opening bundle", "zip", repo.Zip, "err", err)
+        repo.loadErrs = append(repo.loadErrs, err)
+    }
+    return
+}
+
+// Makes loaded bundle repository source if it loaded without errors.
+// Previous bundle stays open while retained snapshots use it.
+// Otherwise returns error, previous bundle and its resources stay active.
+func swapBundle(repo *Repo, bundle *zip.ReadCloser) (err error) {
+    if len(repo.loadErrs) > 0 {
+        err = fmt.Errorf("Bundle not activated due to %d errors: %s", len(repo.loadErrs), repo.Zip)
+        repo.log().Warn("Bundle not activated", "zip", repo.Zip, "errors", len(repo.loadErrs))
+        return
+    }
+
+    repo.bundle = bundle
+    repo.fsys = bundle
+    return
+}
+
+//------------------------------------------------------------
+// Bundle watching
+//------------------------------------------------------------
+
+// Watches bundle directory for changes of bundle file only.
+// Bundle watch is added once and kept across reloads.
+func (repo *Repo) watchBundle() {
+    if len(repo.WatchIds) > 0 {
+        return
+    }
+
+    opts := repo.watchOptions()
+    opts.Include = []string{escapePattern(filepath.Base(repo.Zip))}
+
+    dir := filepath.Dir(repo.Zip)
+    id, err := fwatch.WatchDirPaths(dir, repo.Id, "", onDirChanged, opts)
+    if err != nil {
+        repo.log().Error("Error adding watch", "dir", dir, "err", err)
+        return
+    }
+    repo.setWatchIds(append(repo.WatchIds, id))
+}
+
+// Escapes path.Match meta characters in file name.
+func escapePattern(name string) string {
+    var b strings.Builder
+    for _, c := range name {
+        if strings.ContainsRune(`*?[]\`, c) {
+            b.WriteByte('\\')
+        }
+        b.WriteRune(c)
+    }
+    return b.String()
+}